@@ -1,6 +1,7 @@
 package api
 
 import (
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,14 +18,17 @@ import (
 type HttpConfig struct {
 	Host string
 	Port int
+	// ReviewerHeader names the header holding the moderator's identity
+	ReviewerHeader string
 }
 
 type HttpServer struct {
 	Server *gin.Engine
 	cfg    HttpConfig
+	ctrl   *controllers.Controller
 }
 
-func SetupRouter(workpath string, db database.Client, cfg *config.RawConfig, ls string) (Server, error) {
+func SetupRouter(workpath string, db database.Client, cfg *config.RawConfig, settings controllers.Settings) (Server, error) {
 
 	config := HttpConfig{
 		Host: "",
@@ -35,13 +39,11 @@ func SetupRouter(workpath string, db database.Client, cfg *config.RawConfig, ls
 		return nil, err
 	}
 
-	ctrl, err := controllers.NewController(ls)
+	ctrl, err := controllers.NewController(db, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	ctrl.DB = db
-
 	app := gin.New()
 
 	logger := logger.NewLogger("gin")
@@ -50,6 +52,7 @@ func SetupRouter(workpath string, db database.Client, cfg *config.RawConfig, ls
 	app.Use(middleware.RequestID())
 	app.Use(middleware.ErrorHandler(logger))
 	app.Use(middleware.Logger(logger))
+	app.Use(middleware.Reviewer(config.ReviewerHeader))
 
 	app.NoRoute(func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{
@@ -58,24 +61,49 @@ func SetupRouter(workpath string, db database.Client, cfg *config.RawConfig, ls
 		})
 	})
 
+	app.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
 	api := app.Group("/api")
 	{
 		api.POST("/validate", ctrl.Validate)
+		api.POST("/validate/batch", ctrl.ValidateBatch)
+		api.POST("/preview", ctrl.Preview)
 		api.GET("/messages", ctrl.AllMessages)
+		api.GET("/messages/:id/status", ctrl.MessageStatus)
+		api.PUT("/messages/:id", ctrl.EditMessage)
+		api.GET("/messages/:id/revisions", ctrl.MessageRevisions)
 		api.GET("/rejected", ctrl.Rejected)
 
 		ap := api.Group("/approvals")
 		{
 			ap.POST("/:id/approve", ctrl.Approve)
 			ap.POST("/:id/reject", ctrl.Reject)
+			ap.POST("/:id/claim", ctrl.Claim)
+			ap.DELETE("/:id/claim", ctrl.ReleaseClaim)
+			ap.GET("/next", ctrl.NextApproval)
 			ap.GET("", ctrl.AllApprovals)
 		}
 
+		dec := api.Group("/decisions")
+		{
+			dec.GET("", ctrl.AllDecisions)
+			dec.DELETE("/:id", ctrl.RevokeDecision)
+		}
+
+		admin := api.Group("/admin")
+		{
+			admin.POST("/revalidate", ctrl.Revalidate)
+			admin.GET("/revalidate", ctrl.AllRevalidations)
+			admin.GET("/revalidate/:id", ctrl.RevalidationStatus)
+			admin.POST("/revalidate/:id/resume", ctrl.ResumeRevalidation)
+		}
+
 	}
 
 	h := &HttpServer{
 		Server: app,
 		cfg:    config,
+		ctrl:   ctrl,
 	}
 
 	return h, nil
@@ -94,3 +122,8 @@ func (h *HttpServer) Start() error {
 
 	return nil
 }
+
+// ReloadRules rebuilds the validation rules without restarting the server.
+func (h *HttpServer) ReloadRules(rulesCfg []*config.RawConfig) error {
+	return h.ctrl.LoadRules(rulesCfg)
+}
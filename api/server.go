@@ -1,5 +1,8 @@
 package api
 
+import "github.com/kramllih/filterService/config"
+
 type Server interface {
 	Start() error
+	ReloadRules(rulesCfg []*config.RawConfig) error
 }
@@ -5,15 +5,23 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/kramllih/filterService/api"
 	"github.com/kramllih/filterService/config"
 	"github.com/spf13/viper"
 
+	"github.com/kramllih/filterService/internal/controllers"
 	"github.com/kramllih/filterService/internal/database"
 	_ "github.com/kramllih/filterService/internal/database/bbolt"
-	_ "github.com/kramllih/filterService/internal/logger"
+	"github.com/kramllih/filterService/internal/logger"
+	_ "github.com/kramllih/filterService/internal/rules/bannedwords"
+	_ "github.com/kramllih/filterService/internal/rules/limits"
+	_ "github.com/kramllih/filterService/internal/rules/links"
+	_ "github.com/kramllih/filterService/internal/rules/structure"
 )
 
+var log = logger.NewLogger("main")
+
 func init() {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
@@ -37,9 +45,9 @@ func run() error {
 	}
 
 	var (
-		c      *config.RawConfig
-		apicfg *config.RawConfig
-		ls     string
+		c        *config.RawConfig
+		apicfg   *config.RawConfig
+		settings controllers.Settings
 	)
 
 	err = viper.Unmarshal(&c)
@@ -67,20 +75,90 @@ func run() error {
 		return err
 	}
 
-	err = c.UnpackAttribute("languageservice", &ls)
+	err = c.UnpackAttribute("languageservice", &settings.LanguageService)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("rules", &settings.Rules)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("banned", &settings.Banned)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("images", &settings.Images)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("async", &settings.Async)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("requests", &settings.Requests)
 	if err != nil {
 		return err
 	}
 
-	router, err := api.SetupRouter(path, db, apicfg, ls)
+	err = c.UnpackAttribute("batch", &settings.Batch)
 	if err != nil {
 		return err
 	}
 
+	err = c.UnpackAttribute("revalidate", &settings.Revalidate)
+	if err != nil {
+		return err
+	}
+
+	err = c.UnpackAttribute("approvals", &settings.Approvals)
+	if err != nil {
+		return err
+	}
+
+	router, err := api.SetupRouter(path, db, apicfg, settings)
+	if err != nil {
+		return err
+	}
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		if err := reloadRules(router); err != nil {
+			log.Errorf("unable to reload rules, keeping the current rules: %s", err)
+			return
+		}
+
+		log.Infof("rules reloaded from %s", e.Name)
+	})
+	viper.WatchConfig()
+
 	return router.Start()
 
 }
 
+// reloadRules reads the rules from the config file again and hands them to
+// the server.
+func reloadRules(router api.Server) error {
+
+	var (
+		c        *config.RawConfig
+		rulesCfg []*config.RawConfig
+	)
+
+	if err := viper.Unmarshal(&c); err != nil {
+		return fmt.Errorf("config unmarshal error: %w", err)
+	}
+
+	if err := c.UnpackAttribute("rules", &rulesCfg); err != nil {
+		return err
+	}
+
+	return router.ReloadRules(rulesCfg)
+}
+
 // getWorkingPath gets the working path of the application
 func getWorkingPath() (string, error) {
 
@@ -0,0 +1,140 @@
+package ahocorasick
+
+// Matcher finds every occurrence of a fixed set of patterns in a text in a
+// single pass, however many patterns there are.
+type Matcher struct {
+	nodes []node
+}
+
+type node struct {
+	next map[byte]int32
+	fail int32
+	// dict is the nearest node along the fail chain that ends a pattern,
+	// or -1 when there is none.
+	dict int32
+	// patterns ending at this node
+	out []int
+	// depth is the length in bytes of the path to this node
+	depth int
+}
+
+// Match is an occurrence of Patterns[Pattern] in the text at [Start, End).
+type Match struct {
+	Pattern int
+	Start   int
+	End     int
+}
+
+// New builds a matcher for patterns. Empty patterns are ignored.
+func New(patterns []string) *Matcher {
+
+	m := &Matcher{
+		nodes: []node{newNode(0)},
+	}
+
+	for i, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+
+		cur := int32(0)
+		for j := 0; j < len(pattern); j++ {
+			next, ok := m.nodes[cur].next[pattern[j]]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, newNode(j+1))
+				m.nodes[cur].next[pattern[j]] = next
+			}
+			cur = next
+		}
+
+		m.nodes[cur].out = append(m.nodes[cur].out, i)
+	}
+
+	m.link()
+
+	return m
+}
+
+func newNode(depth int) node {
+	return node{
+		next:  map[byte]int32{},
+		dict:  -1,
+		depth: depth,
+	}
+}
+
+// link sets the fail and dictionary links breadth first.
+func (m *Matcher) link() {
+
+	queue := []int32{}
+
+	for _, child := range m.nodes[0].next {
+		m.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+
+		for b, child := range m.nodes[cur].next {
+			fail := m.nodes[cur].fail
+			for {
+				if next, ok := m.nodes[fail].next[b]; ok {
+					m.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					m.nodes[child].fail = 0
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+
+			f := m.nodes[child].fail
+			if len(m.nodes[f].out) > 0 {
+				m.nodes[child].dict = f
+			} else {
+				m.nodes[child].dict = m.nodes[f].dict
+			}
+
+			queue = append(queue, child)
+		}
+	}
+}
+
+// Find returns every match of every pattern in text, including overlapping
+// matches, in the order they end.
+func (m *Matcher) Find(text string) []Match {
+
+	matches := []Match{}
+	cur := int32(0)
+
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+
+		for {
+			if next, ok := m.nodes[cur].next[b]; ok {
+				cur = next
+				break
+			}
+			if cur == 0 {
+				break
+			}
+			cur = m.nodes[cur].fail
+		}
+
+		for n := cur; n > 0; n = m.nodes[n].dict {
+			for _, p := range m.nodes[n].out {
+				matches = append(matches, Match{
+					Pattern: p,
+					Start:   i + 1 - m.nodes[n].depth,
+					End:     i + 1,
+				})
+			}
+		}
+	}
+
+	return matches
+}
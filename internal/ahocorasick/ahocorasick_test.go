@@ -0,0 +1,68 @@
+package ahocorasick
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// naive finds the same matches as Find by checking every pattern at every
+// position of the text.
+func naive(patterns []string, text string) []Match {
+
+	matches := []Match{}
+
+	for i := 0; i < len(text); i++ {
+		for p, pattern := range patterns {
+			if pattern != "" && strings.HasPrefix(text[i:], pattern) {
+				matches = append(matches, Match{Pattern: p, Start: i, End: i + len(pattern)})
+			}
+		}
+	}
+
+	return matches
+}
+
+func sorted(matches []Match) []Match {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Start != matches[j].Start {
+			return matches[i].Start < matches[j].Start
+		}
+		return matches[i].Pattern < matches[j].Pattern
+	})
+	return matches
+}
+
+func TestFind(t *testing.T) {
+
+	patterns := []string{"he", "she", "his", "hers", "", "s", "ушка"}
+	texts := []string{
+		"ushers",
+		"she sells his hershey",
+		"",
+		"hhhhhe",
+		"кукушка",
+	}
+
+	m := New(patterns)
+
+	for _, text := range texts {
+		assert.Equal(t, sorted(naive(patterns, text)), sorted(m.Find(text)), text)
+	}
+}
+
+func TestFindOffsets(t *testing.T) {
+
+	m := New([]string{"bad word"})
+
+	matches := m.Find("a bad word here")
+
+	assert.Len(t, matches, 1)
+	assert.Equal(t, Match{Pattern: 0, Start: 2, End: 10}, matches[0])
+}
+
+func TestFindNoPatterns(t *testing.T) {
+	assert.Len(t, New(nil).Find("anything"), 0)
+}
@@ -0,0 +1,374 @@
+package banned
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/breaker"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/kramllih/filterService/internal/logger"
+)
+
+const languageservice string = "/api/banned"
+
+// ErrUnavailable is returned when there is no banned word list to use,
+// either from the language service or from the database.
+var ErrUnavailable = errors.New("banned word list is unavailable")
+
+var metrics = expvar.NewMap("bannedWords")
+
+type Config struct {
+	Refresh time.Duration
+	Breaker breaker.Config
+}
+
+// Cache keeps the banned word list from the language service in memory and
+// refreshes it in the background. Every new list is saved to the database
+// so the last good list can be used when the language service is down.
+type Cache struct {
+	http    *httpClient.HTTP
+	db      database.Client
+	log     *logger.Logger
+	refresh time.Duration
+	breaker *breaker.Breaker
+
+	// fetchMu makes sure only one refresh talks to the language service
+	// at a time.
+	fetchMu sync.Mutex
+
+	mu           sync.RWMutex
+	loaded       bool
+	words        []string
+	version      uint64
+	updated      time.Time
+	etag         string
+	lastModified string
+	checked      time.Time
+	// unsaved is set when the list was fetched without being saved
+	unsaved bool
+
+	stop chan struct{}
+	once sync.Once
+}
+
+type response struct {
+	Updated time.Time
+	Words   []string
+}
+
+// NewCache creates the cache, db can be nil in which case the list is not
+// saved.
+func NewCache(http *httpClient.HTTP, db database.Client, cfg *config.RawConfig) (*Cache, error) {
+
+	cacheConfig := Config{
+		Refresh: 5 * time.Minute,
+		Breaker: breaker.Config{
+			Failures: 5,
+			Cooldown: time.Minute,
+		},
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&cacheConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if cacheConfig.Refresh <= 0 {
+		return nil, errors.New("banned word refresh interval must be greater than 0")
+	}
+
+	uri := http.GetURI()
+	if !strings.HasSuffix(uri, "banned") {
+		http.SetURI(uri + languageservice)
+	}
+
+	c := &Cache{
+		http:    http,
+		db:      db,
+		log:     logger.NewLogger("banned"),
+		refresh: cacheConfig.Refresh,
+		breaker: breaker.New(cacheConfig.Breaker),
+		stop:    make(chan struct{}),
+	}
+
+	metrics.Set("ageSeconds", expvar.Func(func() interface{} {
+		return c.Age().Seconds()
+	}))
+	metrics.Set("breaker", expvar.Func(func() interface{} {
+		return c.breaker.State()
+	}))
+
+	return c, nil
+}
+
+// Start loads the list and keeps refreshing it until Stop is called. When
+// the first load fails the last list saved in the database is used.
+func (c *Cache) Start() {
+
+	if err := c.Refresh(); err != nil {
+		c.log.Warnf("unable to load banned word list: %s", err)
+
+		if err := c.loadStored(); err != nil {
+			c.log.Warnf("unable to load banned word list from database: %s", err)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Refresh(); err != nil {
+					c.log.Warnf("unable to refresh banned word list, list is %s old: %s", c.Age().Round(time.Second), err)
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Cache) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+// BannedWords returns the cached list along with its version, which goes
+// up every time the list changes. It only goes to the language service,
+// and then the database, when no list has been loaded yet and returns
+// ErrUnavailable when neither has one.
+func (c *Cache) BannedWords() ([]string, uint64, error) {
+	return c.list(c.Refresh)
+}
+
+// ReadOnlyWords is BannedWords without writing to the database, for dry
+// runs. A list it has to fetch is saved by the next refresh.
+func (c *Cache) ReadOnlyWords() ([]string, uint64, error) {
+	return c.list(func() error {
+		return c.update(false)
+	})
+}
+
+// list returns the cached list, loading it with refresh when there is none.
+func (c *Cache) list(refresh func() error) ([]string, uint64, error) {
+
+	c.mu.RLock()
+	words, version, loaded := c.words, c.version, c.loaded
+	c.mu.RUnlock()
+
+	if loaded {
+		return words, version, nil
+	}
+
+	if err := refresh(); err != nil {
+		if storeErr := c.loadStored(); storeErr != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrUnavailable, err)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.words, c.version, nil
+}
+
+// Age is how long it has been since the list was last confirmed with the
+// language service.
+func (c *Cache) Age() time.Duration {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.checked.IsZero() {
+		return 0
+	}
+
+	return time.Since(c.checked)
+}
+
+// Refresh asks the language service for the list. Calls are made through a
+// circuit breaker, so while the service is failing this returns
+// breaker.ErrOpen without making a request.
+func (c *Cache) Refresh() error {
+	return c.update(true)
+}
+
+// update refreshes the list, only saving it to the database when save is
+// set.
+func (c *Cache) update(save bool) error {
+
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	err := c.breaker.Do(func() error {
+		return c.fetch(save)
+	})
+	if err != nil {
+		metrics.Add("errors", 1)
+	}
+
+	return err
+}
+
+// fetch gets the list using the ETag, Last-Modified and Updated values of
+// the cached list so that an unchanged list is not decoded again.
+func (c *Cache) fetch(save bool) error {
+
+	c.mu.RLock()
+	c.http.SetHeader("If-None-Match", c.etag)
+	c.http.SetHeader("If-Modified-Since", c.lastModified)
+	c.mu.RUnlock()
+
+	res, err := c.http.FetchResponse()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	now := time.Now()
+
+	if res.StatusCode == http.StatusNotModified {
+		c.mu.Lock()
+		c.checked = now
+		if save && c.unsaved {
+			c.save(true)
+		}
+		c.mu.Unlock()
+
+		metrics.Add("notModified", 1)
+		return nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP error %d fetching banned words: %s", res.StatusCode, res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	list := response{}
+
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.etag = res.Header.Get("ETag")
+	c.lastModified = res.Header.Get("Last-Modified")
+	c.checked = now
+
+	if !list.Updated.IsZero() && list.Updated.Equal(c.updated) && c.loaded {
+		if save && c.unsaved {
+			c.save(true)
+		}
+
+		metrics.Add("notModified", 1)
+		return nil
+	}
+
+	c.words = list.Words
+	c.version++
+	c.updated = list.Updated
+	c.loaded = true
+
+	metrics.Add("refreshes", 1)
+	metrics.Set("words", intVar(len(list.Words)))
+
+	c.log.WithField("updated", list.Updated).Infof("banned word list refreshed, %d words", len(list.Words))
+
+	c.save(save)
+
+	return nil
+}
+
+// save stores the cached list, or remembers that it still needs storing
+// when save isn't set. It must be called with mu held.
+func (c *Cache) save(save bool) {
+
+	if !save {
+		c.unsaved = true
+		return
+	}
+
+	c.unsaved = false
+
+	c.store(database.BannedList{
+		Updated: c.updated,
+		Fetched: c.checked,
+		Words:   c.words,
+	})
+}
+
+// store saves the list as the last known good list.
+func (c *Cache) store(list database.BannedList) {
+
+	if c.db == nil {
+		return
+	}
+
+	jsonList, err := json.Marshal(list)
+	if err != nil {
+		c.log.Errorf("unable to encode banned word list: %s", err)
+		return
+	}
+
+	if err := c.db.StoreBannedList(jsonList); err != nil {
+		c.log.Errorf("unable to save banned word list: %s", err)
+	}
+}
+
+// loadStored loads the last known good list from the database.
+func (c *Cache) loadStored() error {
+
+	if c.db == nil {
+		return errors.New("no database configured")
+	}
+
+	list, err := c.db.GetBannedList()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a fetch may have loaded a newer list in the mean time
+	if c.loaded {
+		return nil
+	}
+
+	c.words = list.Words
+	c.version++
+	c.updated = list.Updated
+	c.checked = list.Fetched
+	c.loaded = true
+
+	metrics.Set("words", intVar(len(list.Words)))
+
+	c.log.WithField("updated", list.Updated).Warnf("using banned word list saved %s ago, %d words", time.Since(list.Fetched).Round(time.Second), len(list.Words))
+
+	return nil
+}
+
+func intVar(i int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(i))
+	return v
+}
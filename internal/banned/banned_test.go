@@ -0,0 +1,271 @@
+package banned
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/breaker"
+	"github.com/kramllih/filterService/internal/database"
+	_ "github.com/kramllih/filterService/internal/database/mockdb"
+	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTransport struct {
+	RoundTripFn func(req *http.Request) (*http.Response, error)
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.RoundTripFn(req)
+}
+
+func mockResponse(status int, body string, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Header:     header,
+	}
+}
+
+func TestCacheConditionalRequests(t *testing.T) {
+
+	requests := []*http.Request{}
+
+	mock := httpClient.MockHTTP()
+	mock.SetURI("http://language")
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return mockResponse(http.StatusNotModified, "", nil), nil
+		}
+
+		return mockResponse(http.StatusOK, `{"updated":"2022-06-15T19:17:58Z","words":["adult","jaw"]}`, http.Header{"Etag": []string{`"v1"`}}), nil
+	}})
+
+	cache, err := NewCache(mock, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words, _, err := cache.BannedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult", "jaw"}, words)
+
+	// served from memory
+	_, _, err = cache.BannedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "http://language/api/banned", requests[0].URL.String())
+
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, requests, 2)
+	assert.Equal(t, `"v1"`, requests[1].Header.Get("If-None-Match"))
+
+	words, _, err = cache.BannedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult", "jaw"}, words)
+}
+
+func TestCacheUpdatedComparison(t *testing.T) {
+
+	body := `{"updated":"2022-06-15T19:17:58Z","words":["adult"]}`
+
+	mock := httpClient.MockHTTP()
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		return mockResponse(http.StatusOK, body, nil), nil
+	}})
+
+	cache, err := NewCache(mock, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// same updated time, list is kept
+	body = `{"updated":"2022-06-15T19:17:58Z","words":["ignored"]}`
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	words, version, _ := cache.BannedWords()
+	assert.Equal(t, []string{"adult"}, words)
+	assert.EqualValues(t, 1, version)
+
+	body = `{"updated":"2022-06-16T19:17:58Z","words":["adult","jaw"]}`
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	words, version, _ = cache.BannedWords()
+	assert.Equal(t, []string{"adult", "jaw"}, words)
+	assert.EqualValues(t, 2, version)
+}
+
+func TestCacheErrors(t *testing.T) {
+
+	mock := httpClient.MockHTTP()
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		return mockResponse(http.StatusInternalServerError, "", nil), nil
+	}})
+
+	cache, err := NewCache(mock, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = cache.BannedWords()
+	assert.Error(t, err)
+}
+
+func mockDB(t *testing.T) database.Client {
+
+	cfg := config.RawConfig{
+		"database": map[string]interface{}{
+			"mockDB": map[string]interface{}{},
+		},
+	}
+
+	databaseCfg, err := config.UnpackNamespace("database", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.Load(&databaseCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestCacheLastKnownGood(t *testing.T) {
+
+	db := mockDB(t)
+	up := true
+
+	mock := httpClient.MockHTTP()
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		if !up {
+			return nil, errors.New("connection refused")
+		}
+		return mockResponse(http.StatusOK, `{"updated":"2022-06-15T19:17:58Z","words":["adult"]}`, nil), nil
+	}})
+
+	cache, err := NewCache(mock, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetBannedList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult"}, stored.Words)
+
+	// a new cache with the service down uses the saved list
+	up = false
+
+	restarted, err := NewCache(mock, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words, _, err := restarted.BannedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult"}, words)
+}
+
+func TestCacheReadOnly(t *testing.T) {
+
+	db := mockDB(t)
+
+	mock := httpClient.MockHTTP()
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return mockResponse(http.StatusNotModified, "", nil), nil
+		}
+		return mockResponse(http.StatusOK, `{"updated":"2022-06-15T19:17:58Z","words":["adult"]}`, http.Header{"Etag": []string{`"v1"`}}), nil
+	}})
+
+	cache, err := NewCache(mock, db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words, _, err := cache.ReadOnlyWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult"}, words)
+
+	// nothing is saved by a read only load
+	_, err = db.GetBannedList()
+	assert.Error(t, err)
+
+	// the next refresh saves it, even though the list hasn't changed
+	if err := cache.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetBannedList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"adult"}, stored.Words)
+}
+
+func TestCacheUnavailable(t *testing.T) {
+
+	calls := 0
+
+	mock := httpClient.MockHTTP()
+	mock.SetTransport(&mockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("connection refused")
+	}})
+
+	cache, err := NewCache(mock, mockDB(t), &config.RawConfig{
+		"breaker": map[string]interface{}{
+			"failures": 2,
+			"cooldown": "1h",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, _, err = cache.BannedWords()
+		assert.True(t, errors.Is(err, ErrUnavailable))
+	}
+
+	// the breaker stops calls after two failures
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, breaker.StateOpen, cache.breaker.State())
+}
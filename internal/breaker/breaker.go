@@ -0,0 +1,110 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+const (
+	StateClosed   string = "closed"
+	StateOpen     string = "open"
+	StateHalfOpen string = "half-open"
+)
+
+type Config struct {
+	// Failures is how many calls in a row have to fail before the breaker
+	// opens.
+	Failures int
+	// Cooldown is how long the breaker stays open before letting a single
+	// call through to test the service.
+	Cooldown time.Duration
+}
+
+// Breaker stops calls to a service that keeps failing, so callers fail
+// fast instead of waiting on timeouts.
+type Breaker struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+func New(cfg Config) *Breaker {
+
+	if cfg.Failures <= 0 {
+		cfg.Failures = 5
+	}
+
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = time.Minute
+	}
+
+	return &Breaker{
+		cfg:   cfg,
+		now:   time.Now,
+		state: StateClosed,
+	}
+}
+
+// Do calls fn unless the breaker is open, in which case it returns ErrOpen.
+func (b *Breaker) Do(fn func() error) error {
+
+	if !b.allow() {
+		return ErrOpen
+	}
+
+	err := fn()
+	b.record(err)
+
+	return err
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.Cooldown {
+			return false
+		}
+		b.state = StateHalfOpen
+		return true
+	case StateHalfOpen:
+		// a test call is already in flight
+		return false
+	}
+
+	return true
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.state == StateHalfOpen || b.failures >= b.cfg.Failures {
+		b.state = StateOpen
+		b.openedAt = b.now()
+	}
+}
@@ -0,0 +1,44 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+
+	now := time.Now()
+
+	b := New(Config{Failures: 2, Cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	fail := func() error { return errors.New("failed") }
+	ok := func() error { return nil }
+
+	assert.Error(t, b.Do(fail))
+	assert.Equal(t, StateClosed, b.State())
+
+	assert.Error(t, b.Do(fail))
+	assert.Equal(t, StateOpen, b.State())
+
+	called := false
+	err := b.Do(func() error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, ErrOpen, err)
+	assert.False(t, called)
+
+	// a failed test call opens it again
+	now = now.Add(2 * time.Minute)
+	assert.EqualError(t, b.Do(fail), "failed")
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, ErrOpen, b.Do(ok))
+
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, b.Do(ok))
+	assert.Equal(t, StateClosed, b.State())
+}
@@ -2,129 +2,274 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kramllih/filterService/internal/database"
+	"github.com/sirupsen/logrus"
 )
 
 func (c *Controller) Approve(ctx *gin.Context) {
+	c.decide(ctx, "approved")
+}
+
+func (c *Controller) Reject(ctx *gin.Context) {
+	c.decide(ctx, "rejected")
+}
+
+// decisionRequest is the review of an approval. The reviewer is taken from
+// the request when an auth middleware has set one, and from the body when
+// it hasn't.
+type decisionRequest struct {
+	Reviewer string `json:"reviewer"`
+	Comment  string `json:"comment"`
+	Notes    string `json:"notes"`
+}
+
+// decide records the moderator's decision on an approval.
+func (c *Controller) decide(ctx *gin.Context, status string) {
 
 	id := ctx.Param("id")
 
-	approval, err := c.DB.GetApproval(id)
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+	var req decisionRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	if err := c.DB.DeleteApprovals(approval.ID); err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+	req.Reviewer = reviewerOf(ctx, req.Reviewer)
+	req.Comment = strings.TrimSpace(req.Comment)
+
+	if req.Reviewer == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("a reviewer is required"))
+		return
+	}
+
+	if status == "rejected" && req.Comment == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("a comment is required to reject an approval"))
 		return
 	}
 
-	message, err := c.DB.GetMessage(approval.MessageID)
+	decided, err := c.decideApproval(id, status, req)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
+		abortClaim(ctx, err)
 		return
 	}
 
-	actions := []database.Action{}
-	approvedCount := 0
+	ctx.JSON(http.StatusOK, decided)
+}
 
-	for _, act := range message.Actions {
-		if act.ID != approval.ID {
-			if act.Status == "approved" {
-				approvedCount++
-			}
-			actions = append(actions, act)
-			continue
+// decideApproval writes the decision on an approval. The decided approval,
+// its message and the remembered decisions are written in one transaction
+// so a failure part way through leaves everything as it was.
+func (c *Controller) decideApproval(id, status string, req decisionRequest) (*database.Approval, error) {
+
+	var decided *database.Approval
+
+	err := c.DB.Update(func(tx database.Tx) error {
+
+		approval, err := tx.GetApproval(id)
+		if err != nil {
+			return err
 		}
 
-		act.Status = "approved"
-		actions = append(actions, act)
-		approvedCount++
-	}
+		if approval == nil {
+			return errApprovalNotFound
+		}
 
-	message.Actions = actions
+		if !pending(approval) {
+			return fmt.Errorf("%w, it is %s", errApprovalClosed, approval.Status)
+		}
 
-	if len(actions) == approvedCount {
-		_, _, err := c.handleValidation(message, nil)
+		message, err := tx.GetMessage(approval.MessageID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+
+		if heldByOther(approval, req.Reviewer, now) {
+			return fmt.Errorf("%w until %s", errApprovalClaimed, approval.ClaimExpires.Format(time.RFC3339))
+		}
+
+		if message == nil {
+			return fmt.Errorf("message %s of approval %s does not exist", approval.MessageID, approval.ID)
+		}
+
+		approval.Status = status
+		approval.DecidedBy = req.Reviewer
+		approval.DecidedAt = &now
+		approval.Comment = req.Comment
+		approval.Notes = req.Notes
+		approval.ClaimedBy = ""
+		approval.ClaimExpires = nil
+
+		// the decided approval is kept as the record of the review
+		jsonApproval, err := json.Marshal(approval)
 		if err != nil {
-			ctx.AbortWithError(http.StatusInternalServerError, err)
-			return
+			return err
+		}
+
+		if err := tx.UpdateApprovals(approval.ID, jsonApproval); err != nil {
+			return err
 		}
+
+		// only a moderator's decision is reused on later messages
+		if req.Reviewer != slaReviewer {
+			if err := c.rememberDecision(tx, approval, status); err != nil {
+				return err
+			}
+		}
+
+		decided = approval
+
+		if status == "rejected" {
+			return rejectMessage(tx, message, approval)
+		}
+
+		return approveMessage(tx, message, approval)
+	})
+
+	if errors.Is(err, errApprovalNotFound) || errors.Is(err, errApprovalClaimed) || errors.Is(err, errApprovalClosed) {
+		return nil, err
 	}
 
-	updatedMessage, err := json.Marshal(message)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		c.log.WithField("approvalId", id).Errorf("unable to record decision: %s", err)
+		return nil, err
 	}
 
-	if err := c.DB.UpdateMessage(approval.MessageID, updatedMessage); err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
-	}
+	c.log.WithFields(logrus.Fields{"approvalId": id, "reviewer": decided.DecidedBy}).Infof("approval with ID [%s] %s by %s", id, status, decided.DecidedBy)
 
+	return decided, nil
 }
 
-func (c *Controller) Reject(ctx *gin.Context) {
+var (
+	errApprovalNotFound = errors.New("approval does not exist")
+	errApprovalClosed   = errors.New("approval has been closed")
+)
 
-	id := ctx.Param("id")
+// review copies the decision on the approval onto its action.
+func review(act *database.Action, approval *database.Approval) {
+	act.Status = approval.Status
+	act.DecidedBy = approval.DecidedBy
+	act.DecidedAt = approval.DecidedAt
+	act.Comment = approval.Comment
+	act.Notes = approval.Notes
+}
 
-	approval, err := c.DB.GetApproval(id)
-	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+// approveMessage marks the action as approved, the message is validated once
+// all of its actions are.
+func approveMessage(tx database.Tx, message *database.Message, approval *database.Approval) error {
+
+	actions := []database.Action{}
+	approvedCount := 0
+
+	for _, act := range message.Actions {
+		if act.ID == approval.ID {
+			review(&act, approval)
+		}
+
+		if act.Status == "approved" {
+			approvedCount++
+		}
+
+		actions = append(actions, act)
 	}
 
-	if err := c.DB.DeleteApprovals(approval.ID); err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+	message.Actions = actions
+
+	if len(actions) == approvedCount {
+		message.Status = "validated"
 	}
 
-	message, err := c.DB.GetMessage(approval.MessageID)
+	updatedMessage, err := json.Marshal(message)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return err
 	}
 
+	return tx.UpdateMessage(message.ID, updatedMessage)
+}
+
+// rejectMessage rejects the message along with the action. The message's
+// other pending approvals can no longer change anything, so they and their
+// actions are marked as superseded by the rejection.
+func rejectMessage(tx database.Tx, message *database.Message, approval *database.Approval) error {
+
 	actions := []database.Action{}
-	rejectedCount := 0
 
 	for _, act := range message.Actions {
-		if act.ID == approval.ID {
-			act.Status = "rejected"
-			actions = append(actions, act)
-			rejectedCount++
-		}
+		switch {
+		case act.ID == approval.ID:
+			review(&act, approval)
+		case act.Status == "pending":
+			if err := supersedeApproval(tx, act.ID, approval.ID); err != nil {
+				return err
+			}
 
-	}
+			act.Status = "superseded"
+			act.SupersededBy = approval.ID
+		}
 
-	if rejectedCount >= 1 {
-		message.Status = "rejected"
+		actions = append(actions, act)
 	}
 
+	// the comment is passed on so the author knows what to change
+	message.Status = "rejected"
+	message.Reason = fmt.Sprintf("rejected on review: %s", approval.Comment)
 	message.Actions = actions
 
 	updatedMessage, err := json.Marshal(message)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return err
 	}
 
-	if err := c.DB.StoreReject(approval.MessageID, updatedMessage); err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+	if err := tx.StoreReject(message.ID, updatedMessage); err != nil {
+		return err
 	}
 
-	if err := c.DB.StoreMessage(approval.MessageID, updatedMessage); err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+	return tx.UpdateMessage(message.ID, updatedMessage)
+}
+
+// supersedeApproval closes a pending approval, it is kept so the approvals
+// show why it was closed. rejectedBy is empty when the message was edited.
+func supersedeApproval(tx database.Tx, id, rejectedBy string) error {
+
+	approval, err := tx.GetApproval(id)
+	if err != nil {
+		return fmt.Errorf("unable to load approval %s: %w", id, err)
+	}
+
+	if approval == nil {
+		return nil
+	}
+
+	approval.Status = "superseded"
+	approval.SupersededBy = rejectedBy
+	approval.ClaimedBy = ""
+	approval.ClaimExpires = nil
+
+	jsonApproval, err := json.Marshal(approval)
+	if err != nil {
+		return err
 	}
 
+	if err := tx.UpdateApprovals(id, jsonApproval); err != nil {
+		return fmt.Errorf("unable to supersede approval %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// pending reports whether the approval is still waiting for a decision.
+func pending(approval *database.Approval) bool {
+	return approval.Status == "" || approval.Status == "pending"
 }
 
 func (c *Controller) AllApprovals(ctx *gin.Context) {
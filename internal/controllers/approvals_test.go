@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func decide(t *testing.T, handler gin.HandlerFunc, id string) int {
+	code, _ := decideAs(t, handler, id, "moderator", gin.H{"comment": "checked"})
+	return code
+}
+
+// decideAs sends a decision, reviewer is set as an auth middleware would and
+// left out when it is empty.
+func decideAs(t *testing.T, handler gin.HandlerFunc, id string, reviewer string, body interface{}) (int, *database.Approval) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
+
+	if reviewer != "" {
+		ctx.Set(middleware.ReviewerKey, reviewer)
+	}
+
+	if body != nil {
+		MockJsonPost(ctx, body)
+	}
+
+	handler(ctx)
+
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	approval := &database.Approval{}
+	if err := json.Unmarshal(w.Body.Bytes(), approval); err != nil {
+		return w.Code, nil
+	}
+
+	return w.Code, approval
+}
+
+func TestReviewDecision(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Title\n\n![cat](https://example.com/cat.png)\n\n![dog](https://example.com/dog.png)",
+	})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	cat, dog := message.Actions[0].ID, message.Actions[1].ID
+
+	code, _ := decideAs(t, ctrl.Approve, cat, "", nil)
+	assert.EqualValues(t, http.StatusBadRequest, code)
+
+	code, _ = decideAs(t, ctrl.Reject, dog, "alice", gin.H{"notes": "no comment"})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+
+	// the reviewer set by the auth middleware wins over the body
+	code, approval := decideAs(t, ctrl.Approve, cat, "alice", gin.H{"reviewer": "mallory", "notes": "looks fine"})
+	assert.EqualValues(t, http.StatusOK, code)
+	if assert.NotNil(t, approval) {
+		assert.Equal(t, "approved", approval.Status)
+		assert.Equal(t, "alice", approval.DecidedBy)
+		assert.NotNil(t, approval.DecidedAt)
+	}
+
+	code, approval = decideAs(t, ctrl.Reject, dog, "", gin.H{"reviewer": "bob", "comment": "not a dog"})
+	assert.EqualValues(t, http.StatusOK, code)
+	if assert.NotNil(t, approval) {
+		assert.Equal(t, "bob", approval.DecidedBy)
+		assert.Equal(t, "not a dog", approval.Comment)
+	}
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "rejected", message.Status)
+	assert.Equal(t, "rejected on review: not a dog", message.Reason)
+
+	assert.Equal(t, "alice", message.Actions[0].DecidedBy)
+	assert.Equal(t, "looks fine", message.Actions[0].Notes)
+	assert.NotNil(t, message.Actions[0].DecidedAt)
+	assert.Equal(t, "bob", message.Actions[1].DecidedBy)
+	assert.Equal(t, "not a dog", message.Actions[1].Comment)
+
+	// the review is kept on the approvals too
+	stored, _ := ctrl.DB.GetApproval(cat)
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, "approved", stored.Status)
+		assert.Equal(t, "alice", stored.DecidedBy)
+		assert.Equal(t, "looks fine", stored.Notes)
+		assert.NotNil(t, stored.DecidedAt)
+	}
+
+	stored, _ = ctrl.DB.GetApproval(dog)
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, "rejected", stored.Status)
+		assert.Equal(t, "bob", stored.DecidedBy)
+		assert.Equal(t, "not a dog", stored.Comment)
+		assert.NotNil(t, stored.DecidedAt)
+	}
+
+	// a decided approval can't be decided again
+	code, _ = decideAs(t, ctrl.Reject, cat, "bob", gin.H{"comment": "changed my mind"})
+	assert.EqualValues(t, http.StatusConflict, code)
+
+	decisions, _ := ctrl.DB.GetAllDecisions()
+	for _, decision := range decisions {
+		assert.Contains(t, []string{"alice", "bob"}, decision.DecidedBy)
+	}
+}
+
+func TestRejectSupersedesSiblings(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Title\n\n![cat](https://example.com/cat.png)\n\n![dog](https://example.com/dog.png)\n\n![owl](https://example.com/owl.png)",
+	})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	if !assert.Len(t, message.Actions, 3) {
+		return
+	}
+	cat, dog, owl := message.Actions[0].ID, message.Actions[1].ID, message.Actions[2].ID
+
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, cat))
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Reject, dog))
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "rejected", message.Status)
+	if assert.Len(t, message.Actions, 3) {
+		assert.Equal(t, "approved", message.Actions[0].Status)
+		assert.Equal(t, "rejected", message.Actions[1].Status)
+		assert.Equal(t, "superseded", message.Actions[2].Status)
+		assert.Equal(t, dog, message.Actions[2].SupersededBy)
+		assert.Empty(t, message.Actions[0].SupersededBy)
+	}
+
+	// the closed approval is kept with what closed it
+	approval, _ := ctrl.DB.GetApproval(owl)
+	if assert.NotNil(t, approval) {
+		assert.Equal(t, "superseded", approval.Status)
+		assert.Equal(t, dog, approval.SupersededBy)
+	}
+
+	// and the link leads to the rejection, from the approval and the action
+	for _, id := range []string{approval.SupersededBy, message.Actions[2].SupersededBy} {
+		rejection, _ := ctrl.DB.GetApproval(id)
+		if assert.NotNil(t, rejection) {
+			assert.Equal(t, "rejected", rejection.Status)
+			assert.Equal(t, "1", rejection.MessageID)
+			assert.NotEmpty(t, rejection.Comment)
+		}
+	}
+
+	assert.EqualValues(t, http.StatusConflict, decide(t, ctrl.Approve, owl))
+
+	code, _ := decideAs(t, ctrl.Claim, owl, "alice", nil)
+	assert.EqualValues(t, http.StatusConflict, code)
+
+	code, _ = decideAs(t, ctrl.NextApproval, "", "alice", nil)
+	assert.EqualValues(t, http.StatusNoContent, code)
+}
+
+func TestDecisionRollback(t *testing.T) {
+
+	ctrl := newTestController(t)
+	db := ctrl.DB
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	id := message.Actions[0].ID
+
+	ctrl.DB = failingDB{db}
+
+	for _, handler := range []gin.HandlerFunc{ctrl.Approve, ctrl.Reject} {
+		assert.EqualValues(t, http.StatusInternalServerError, decide(t, handler, id))
+
+		// nothing the decision wrote before the failure is kept
+		approval, _ := db.GetApproval(id)
+		assert.NotNil(t, approval)
+
+		decisions, _ := db.GetAllDecisions()
+		assert.Len(t, decisions, 0)
+
+		rejected, _ := db.GetAllRejected()
+		assert.Len(t, rejected, 0)
+
+		message, _ := db.GetMessage("1")
+		assert.Equal(t, "awaiting approval", message.Status)
+	}
+
+	ctrl.DB = db
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, id))
+
+	message, _ = db.GetMessage("1")
+	assert.Equal(t, "validated", message.Status)
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+)
+
+type BatchConfig struct {
+	Concurrency int
+	MaxItems    int
+	// MaxBytes is the largest request body a batch can have.
+	MaxBytes int64
+}
+
+func newBatchConfig(cfg *config.RawConfig) (BatchConfig, error) {
+
+	batchConfig := BatchConfig{
+		Concurrency: 8,
+		MaxItems:    100,
+		MaxBytes:    16 << 20,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&batchConfig); err != nil {
+			return BatchConfig{}, err
+		}
+	}
+
+	if batchConfig.Concurrency < 1 {
+		return BatchConfig{}, errors.New("batch concurrency must be at least 1")
+	}
+
+	if batchConfig.MaxItems < 1 {
+		return BatchConfig{}, errors.New("batch maxItems must be at least 1")
+	}
+
+	if batchConfig.MaxBytes < 1 {
+		return BatchConfig{}, errors.New("batch maxBytes must be at least 1")
+	}
+
+	return batchConfig, nil
+}
+
+// ValidateBatch validates an array of messages, up to the configured number
+// at a time. Every message gets its own result in the same order as the
+// request, one bad message doesn't stop the others.
+func (c *Controller) ValidateBatch(ctx *gin.Context) {
+
+	async, err := queryBool(ctx, "async")
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	var items []json.RawMessage
+
+	if code, err := bindBody(ctx, &items, c.batch.MaxBytes); err != nil {
+		ctx.AbortWithError(code, err)
+		return
+	}
+
+	if len(items) == 0 {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("batch is empty"))
+		return
+	}
+
+	if len(items) > c.batch.MaxItems {
+		ctx.AbortWithError(http.StatusRequestEntityTooLarge, fmt.Errorf("batch has %d messages, the limit is %d", len(items), c.batch.MaxItems))
+		return
+	}
+
+	results := make([]gin.H, len(items))
+	seen := map[string]int{}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, c.batch.Concurrency)
+
+	for i, item := range items {
+		var message database.Message
+
+		err := json.Unmarshal(item, &message)
+		if err == nil {
+			err = binding.Validator.ValidateStruct(&message)
+		}
+
+		if err != nil {
+			results[i] = batchResult(i, message.ID, outcome{code: http.StatusBadRequest, err: err})
+			continue
+		}
+
+		// two messages with the same id would race to be stored
+		if first, ok := seen[message.ID]; ok {
+			results[i] = batchResult(i, message.ID, outcome{code: http.StatusBadRequest, err: fmt.Errorf("id is repeated in the batch, first used at %d", first)})
+			continue
+		}
+		seen[message.ID] = i
+
+		wg.Add(1)
+		limit <- struct{}{}
+
+		go func(i int, message database.Message) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			results[i] = batchResult(i, message.ID, c.validateMessage(&message, async))
+		}(i, message)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if _, ok := result["error"]; ok {
+			failed++
+		}
+	}
+
+	c.log.Infof("validated batch of %d messages, %d failed", len(items), failed)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"failed":  failed,
+	})
+}
+
+// batchResult is the result for one message of a batch, in the same shape
+// as the response to a single message with the status code and position
+// added.
+func batchResult(index int, id string, out outcome) gin.H {
+
+	result := gin.H{
+		"index": index,
+		"id":    id,
+		"code":  out.code,
+	}
+
+	if out.err != nil {
+		result["error"] = out.err.Error()
+	}
+
+	for k, v := range out.body {
+		result[k] = v
+	}
+
+	return result
+}
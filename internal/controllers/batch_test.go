@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func validateBatch(t *testing.T, ctrl *Controller, messages interface{}) (int, map[string]interface{}) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate/batch", nil)
+
+	MockJsonPost(ctx, messages)
+
+	ctrl.ValidateBatch(ctx)
+
+	result := map[string]interface{}{}
+
+	if w.Body.Len() > 0 {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code, result
+}
+
+func TestValidateBatch(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	code, _ := validate(t, ctrl, database.Message{ID: "existing", Body: "# Existing\n\ntext"})
+	assert.Equal(t, http.StatusOK, code)
+
+	code, result := validateBatch(t, ctrl, []interface{}{
+		database.Message{ID: "1", Body: "# Plain\n\nSome text"},
+		database.Message{ID: "2", Body: "# Image\n\n![tower](https://example.com/tower.jpg)"},
+		database.Message{ID: "3", Body: "# Banned\n\nA flower"},
+		map[string]string{"id": "4"},
+		database.Message{ID: "5", Body: "No heading"},
+		database.Message{ID: "1", Body: "# Repeated\n\ntext"},
+		database.Message{ID: "existing", Body: "# Existing\n\ntext"},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.EqualValues(t, 4, result["failed"])
+
+	results := result["results"].([]interface{})
+	assert.Len(t, results, 7)
+
+	want := []struct {
+		code   int
+		status string
+	}{
+		{http.StatusOK, "your message has been stored."},
+		{http.StatusOK, "your message is awaiting approval as it contains image links."},
+		{http.StatusOK, "your message has has been rejected."},
+		{http.StatusBadRequest, ""},
+		{http.StatusBadRequest, ""},
+		{http.StatusBadRequest, ""},
+		{http.StatusInternalServerError, ""},
+	}
+
+	for i, w := range want {
+		r := results[i].(map[string]interface{})
+
+		assert.EqualValues(t, i, r["index"])
+		assert.EqualValues(t, w.code, r["code"], i)
+
+		if w.status == "" {
+			assert.NotEmpty(t, r["error"], i)
+			continue
+		}
+
+		assert.Equal(t, w.status, r["status"], i)
+	}
+
+	assert.Contains(t, results[2].(map[string]interface{})["reason"], "flower")
+}
+
+func TestValidateBatchLimit(t *testing.T) {
+
+	ctrl := newTestController(t)
+	ctrl.batch.MaxItems = 1
+
+	code, _ := validateBatch(t, ctrl, []database.Message{
+		{ID: "1", Body: "# One\n\ntext"},
+		{ID: "2", Body: "# Two\n\ntext"},
+	})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _ = validateBatch(t, ctrl, []database.Message{})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+type ApprovalsConfig struct {
+	// Lease is how long a moderator holds an approval they have claimed.
+	Lease time.Duration
+	// SLA is how long an approval waits before it is escalated and
+	// Deadline how long before Outcome is applied, 0 turns either off.
+	SLA      time.Duration
+	Deadline time.Duration
+	Outcome  string
+	// Webhook is sent every approval that is escalated.
+	Webhook string
+	// Interval is how often the approvals are checked against the SLA.
+	Interval time.Duration
+}
+
+func newApprovalsConfig(cfg *config.RawConfig) (ApprovalsConfig, error) {
+
+	approvalsConfig := ApprovalsConfig{
+		Lease:    10 * time.Minute,
+		SLA:      24 * time.Hour,
+		Outcome:  "reject",
+		Interval: time.Minute,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&approvalsConfig); err != nil {
+			return ApprovalsConfig{}, err
+		}
+	}
+
+	if approvalsConfig.Lease <= 0 {
+		return ApprovalsConfig{}, errors.New("approvals lease must be more than 0")
+	}
+
+	if approvalsConfig.SLA < 0 || approvalsConfig.Deadline < 0 {
+		return ApprovalsConfig{}, errors.New("approvals sla and deadline can't be negative")
+	}
+
+	if approvalsConfig.Interval <= 0 {
+		return ApprovalsConfig{}, errors.New("approvals interval must be more than 0")
+	}
+
+	if _, ok := outcomes[approvalsConfig.Outcome]; !ok {
+		return ApprovalsConfig{}, fmt.Errorf("invalid approvals outcome %q, must be reject or approve", approvalsConfig.Outcome)
+	}
+
+	return approvalsConfig, nil
+}
+
+var errApprovalClaimed = errors.New("approval is claimed by another moderator")
+
+type claimRequest struct {
+	Reviewer string `json:"reviewer"`
+}
+
+// reviewerOf returns the moderator making the request, the one set by an
+// auth middleware wins over the one the request names.
+func reviewerOf(ctx *gin.Context, named string) string {
+
+	if reviewer := ctx.GetString(middleware.ReviewerKey); reviewer != "" {
+		return reviewer
+	}
+
+	return strings.TrimSpace(named)
+}
+
+// heldByOther reports whether someone other than reviewer has a lease on
+// the approval that hasn't expired.
+func heldByOther(approval *database.Approval, reviewer string, now time.Time) bool {
+	return approval.ClaimedBy != "" &&
+		approval.ClaimedBy != reviewer &&
+		approval.ClaimExpires != nil &&
+		now.Before(*approval.ClaimExpires)
+}
+
+// Claim gives the moderator a lease on an approval so nobody else picks it
+// up. Claiming an approval again renews the lease.
+func (c *Controller) Claim(ctx *gin.Context) {
+
+	var req claimRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	reviewer := reviewerOf(ctx, req.Reviewer)
+	if reviewer == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("a reviewer is required"))
+		return
+	}
+
+	approval, err := c.updateClaim(ctx.Param("id"), reviewer, func(approval *database.Approval, now time.Time) {
+		expires := now.Add(c.approvals.Lease)
+
+		approval.ClaimedBy = reviewer
+		approval.ClaimExpires = &expires
+	})
+	if err != nil {
+		abortClaim(ctx, err)
+		return
+	}
+
+	c.log.WithFields(logrus.Fields{"approvalId": approval.ID, "reviewer": reviewer}).Infof("approval with ID [%s] claimed by %s until %s", approval.ID, reviewer, approval.ClaimExpires)
+
+	ctx.JSON(http.StatusOK, approval)
+}
+
+// ReleaseClaim gives up the moderator's lease on an approval.
+func (c *Controller) ReleaseClaim(ctx *gin.Context) {
+
+	reviewer := reviewerOf(ctx, ctx.Query("reviewer"))
+	if reviewer == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("a reviewer is required"))
+		return
+	}
+
+	approval, err := c.updateClaim(ctx.Param("id"), reviewer, func(approval *database.Approval, now time.Time) {
+		approval.ClaimedBy = ""
+		approval.ClaimExpires = nil
+	})
+	if err != nil {
+		abortClaim(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, approval)
+}
+
+// updateClaim changes the lease on an approval, unless another moderator
+// holds it.
+func (c *Controller) updateClaim(id, reviewer string, change func(*database.Approval, time.Time)) (*database.Approval, error) {
+
+	var claimed *database.Approval
+
+	err := c.DB.Update(func(tx database.Tx) error {
+
+		approval, err := tx.GetApproval(id)
+		if err != nil {
+			return err
+		}
+
+		if approval == nil {
+			return errApprovalNotFound
+		}
+
+		if !pending(approval) {
+			return fmt.Errorf("%w, it is %s", errApprovalClosed, approval.Status)
+		}
+
+		now := time.Now().UTC()
+
+		if heldByOther(approval, reviewer, now) {
+			return fmt.Errorf("%w until %s", errApprovalClaimed, approval.ClaimExpires.Format(time.RFC3339))
+		}
+
+		change(approval, now)
+
+		jsonApproval, err := json.Marshal(approval)
+		if err != nil {
+			return err
+		}
+
+		claimed = approval
+
+		return tx.UpdateApprovals(approval.ID, jsonApproval)
+	})
+
+	return claimed, err
+}
+
+func abortClaim(ctx *gin.Context, err error) {
+
+	switch {
+	case errors.Is(err, errApprovalNotFound):
+		ctx.AbortWithError(http.StatusNotFound, err)
+	case errors.Is(err, errApprovalClaimed), errors.Is(err, errApprovalClosed):
+		ctx.AbortWithError(http.StatusConflict, err)
+	default:
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+	}
+}
+
+// NextApproval returns the first pending approval the moderator can work
+// on, any approval another moderator holds is skipped. It isn't claimed, so it can
+// be looked at before deciding to claim it.
+func (c *Controller) NextApproval(ctx *gin.Context) {
+
+	reviewer := reviewerOf(ctx, ctx.Query("reviewer"))
+	if reviewer == "" {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("a reviewer is required"))
+		return
+	}
+
+	approvals, err := c.DB.GetAllApprovals()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// escalated approvals come first, then the ones that have waited longest
+	sort.Slice(approvals, func(i, j int) bool {
+		if (approvals[i].EscalatedAt != nil) != (approvals[j].EscalatedAt != nil) {
+			return approvals[i].EscalatedAt != nil
+		}
+		if !approvals[i].CreatedAt.Equal(approvals[j].CreatedAt) {
+			return approvals[i].CreatedAt.Before(approvals[j].CreatedAt)
+		}
+		return approvals[i].ID < approvals[j].ID
+	})
+
+	now := time.Now().UTC()
+
+	for _, approval := range approvals {
+		if pending(approval) && !heldByOther(approval, reviewer, now) {
+			ctx.JSON(http.StatusOK, approval)
+			return
+		}
+	}
+
+	ctx.Status(http.StatusNoContent)
+	ctx.Writer.WriteHeaderNow()
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimApproval(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Title\n\n![cat](https://example.com/cat.png)\n\n![dog](https://example.com/dog.png)",
+	})
+
+	code, next := decideAs(t, ctrl.NextApproval, "", "alice", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+	if !assert.NotNil(t, next) {
+		return
+	}
+	first := next.ID
+
+	code, claimed := decideAs(t, ctrl.Claim, first, "alice", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+	if assert.NotNil(t, claimed) {
+		assert.Equal(t, "alice", claimed.ClaimedBy)
+		assert.NotNil(t, claimed.ClaimExpires)
+	}
+
+	// alice still sees her own claim, bob is given the other approval
+	_, next = decideAs(t, ctrl.NextApproval, "", "alice", nil)
+	assert.Equal(t, first, next.ID)
+
+	_, next = decideAs(t, ctrl.NextApproval, "", "bob", nil)
+	second := next.ID
+	assert.NotEqual(t, first, second)
+
+	code, _ = decideAs(t, ctrl.Claim, first, "bob", nil)
+	assert.EqualValues(t, http.StatusConflict, code)
+
+	code, _ = decideAs(t, ctrl.Approve, first, "bob", nil)
+	assert.EqualValues(t, http.StatusConflict, code)
+
+	code, _ = decideAs(t, ctrl.Claim, second, "bob", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+
+	code, _ = decideAs(t, ctrl.NextApproval, "", "carol", nil)
+	assert.EqualValues(t, http.StatusNoContent, code)
+
+	code, _ = decideAs(t, ctrl.ReleaseClaim, second, "bob", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+
+	_, next = decideAs(t, ctrl.NextApproval, "", "carol", nil)
+	assert.Equal(t, second, next.ID)
+
+	// once the lease runs out anyone can pick the approval up
+	approval, _ := ctrl.DB.GetApproval(first)
+	expired := time.Now().UTC().Add(-time.Second)
+	approval.ClaimExpires = &expired
+	jsonApproval, _ := json.Marshal(approval)
+	if err := ctrl.DB.UpdateApprovals(first, jsonApproval); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ = decideAs(t, ctrl.Approve, first, "bob", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+
+	code, _ = decideAs(t, ctrl.Claim, first, "alice", nil)
+	assert.EqualValues(t, http.StatusConflict, code)
+}
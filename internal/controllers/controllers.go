@@ -1,36 +1,169 @@
 package controllers
 
 import (
+	"errors"
+	"sync"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/banned"
 	"github.com/kramllih/filterService/internal/database"
 	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/kramllih/filterService/internal/imageprobe"
 	"github.com/kramllih/filterService/internal/logger"
+	"github.com/kramllih/filterService/internal/rules"
 )
 
 type Config struct {
 	Host string
 }
 
+type RequestsConfig struct {
+	// MaxBytes is the largest request body a single message can have.
+	MaxBytes int64
+}
+
+func newRequestsConfig(cfg *config.RawConfig) (RequestsConfig, error) {
+
+	requestsConfig := RequestsConfig{
+		MaxBytes: 1 << 20,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&requestsConfig); err != nil {
+			return RequestsConfig{}, err
+		}
+	}
+
+	if requestsConfig.MaxBytes < 1 {
+		return RequestsConfig{}, errors.New("requests maxBytes must be at least 1")
+	}
+
+	return requestsConfig, nil
+}
+
 type Controller struct {
 	httpClient *httpClient.HTTP
 	DB         database.Client
 	log        *logger.Logger
+	banned     *banned.Cache
+	images     *imageprobe.Prober
+
+	mu       sync.RWMutex
+	pipeline *rules.Pipeline
+
+	queue     *queue
+	requests  RequestsConfig
+	batch     BatchConfig
+	jobs      *jobs
+	approvals ApprovalsConfig
+	webhook   *httpClient.HTTP
+}
+
+// Settings are the parts of the config the controller is built from.
+type Settings struct {
+	LanguageService string
+	Rules           []*config.RawConfig
+	Banned          *config.RawConfig
+	Images          *config.RawConfig
+	Async           *config.RawConfig
+	Batch           *config.RawConfig
+	Revalidate      *config.RawConfig
+	Approvals       *config.RawConfig
+	Requests        *config.RawConfig
 }
 
-func NewController(ls string) (*Controller, error) {
+func NewController(db database.Client, settings Settings) (*Controller, error) {
 
 	config := Config{
 		Host: "http://localhost:8081",
 	}
 
-	if ls != "" {
-		config.Host = ls
+	if settings.LanguageService != "" {
+		config.Host = settings.LanguageService
 	}
 
 	http := httpClient.NewHTTP()
 	http.SetURI(config.Host)
 
-	return &Controller{
+	cache, err := banned.NewCache(http, db, settings.Banned)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := imageprobe.New(httpClient.NewHTTP(), settings.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, workers, err := newQueue(settings.Async)
+	if err != nil {
+		return nil, err
+	}
+
+	ctrl := &Controller{
 		log:        logger.NewLogger("controller"),
 		httpClient: http,
-	}, nil
+		DB:         db,
+		banned:     cache,
+		images:     images,
+		queue:      queue,
+		webhook:    httpClient.NewHTTP(),
+	}
+
+	if ctrl.requests, err = newRequestsConfig(settings.Requests); err != nil {
+		return nil, err
+	}
+
+	if ctrl.batch, err = newBatchConfig(settings.Batch); err != nil {
+		return nil, err
+	}
+
+	if ctrl.jobs, err = newJobs(settings.Revalidate); err != nil {
+		return nil, err
+	}
+
+	if ctrl.approvals, err = newApprovalsConfig(settings.Approvals); err != nil {
+		return nil, err
+	}
+
+	if err := ctrl.LoadRules(settings.Rules); err != nil {
+		return nil, err
+	}
+
+	// only once the config is known to be good, so nothing is left
+	// running when it isn't
+	cache.Start()
+
+	ctrl.startWorkers(workers)
+	ctrl.resumeJobs()
+	ctrl.startSLA()
+
+	return ctrl, nil
+}
+
+// LoadRules builds the validation pipeline from the rules config. It can be
+// called while messages are being validated, if the config is invalid the
+// current pipeline is kept.
+func (c *Controller) LoadRules(rulesCfg []*config.RawConfig) error {
+
+	pipeline, err := rules.NewPipeline(rulesCfg, rules.Deps{
+		Words:  c.banned,
+		Images: c.images,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.pipeline = pipeline
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *Controller) rules() *rules.Pipeline {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.pipeline
 }
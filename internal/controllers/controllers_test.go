@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewControllerInvalidConfig(t *testing.T) {
+
+	var requests int32
+
+	languageService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"words":[]}`))
+	}))
+	defer languageService.Close()
+
+	_, err := NewController(mockDB(t), Settings{
+		LanguageService: languageService.URL,
+		Batch:           &config.RawConfig{"concurrency": 0},
+	})
+	assert.Error(t, err)
+
+	// the banned word cache was never started
+	assert.EqualValues(t, 0, atomic.LoadInt32(&requests))
+}
+
+func TestReloadRules(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	message := database.Message{
+		ID:   "1",
+		Body: "# Links\n\n[docs](https://docs.example.com/start)",
+	}
+
+	_, result := validate(t, ctrl, message)
+	assert.Equal(t, "your message has has been rejected.", result["status"])
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "bannedwords"},
+		{"type": "links", "allow": []interface{}{"docs.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message.ID = "2"
+	_, result = validate(t, ctrl, message)
+	assert.Equal(t, "your message has been stored.", result["status"])
+
+	// a bad config keeps the current rules
+	err = ctrl.LoadRules([]*config.RawConfig{{"type": "unknown"}})
+	assert.Error(t, err)
+
+	message.ID = "3"
+	_, result = validate(t, ctrl, message)
+	assert.Equal(t, "your message has been stored.", result["status"])
+}
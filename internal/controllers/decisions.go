@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/rules"
+)
+
+// normalizeURL puts raw into a single form so the same link written in a
+// different way is remembered as one.
+func normalizeURL(raw string) string {
+
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+
+	// scheme relative links are fetched over https
+	if u.Scheme == "" {
+		u.Scheme = "https"
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	port := u.Port()
+
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	u.RawQuery = u.Query().Encode()
+
+	return u.String()
+}
+
+func urlKey(raw string) string {
+	return "url:" + normalizeURL(raw)
+}
+
+func hashKey(hash string) string {
+	return "hash:" + hash
+}
+
+func decisionID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// findDecision returns an earlier decision on a link, or nil when there
+// isn't one. A decision on the same content is used first. A decision on
+// the url is only used when its content is unknown or hasn't changed.
+func (c *Controller) findDecision(target, hash string) (*database.Decision, error) {
+
+	if hash != "" {
+		decision, err := c.DB.GetDecision(decisionID(hashKey(hash)))
+		if err != nil || decision != nil {
+			return decision, err
+		}
+	}
+
+	decision, err := c.DB.GetDecision(decisionID(urlKey(target)))
+	if err != nil || decision == nil {
+		return nil, err
+	}
+
+	if hash != "" && decision.Hash != "" && decision.Hash != hash {
+		return nil, nil
+	}
+
+	return decision, nil
+}
+
+// applyDecisions reuses earlier decisions for the links that need review.
+// Links that were approved before are taken out of the findings and
+// returned as approved actions, links that were rejected before become
+// reject findings. Either way the reason says which decision was reused.
+func (c *Controller) applyDecisions(findings []rules.Finding) ([]rules.Finding, []database.Action, error) {
+
+	actions := []database.Action{}
+	kept := []rules.Finding{}
+
+	for _, f := range findings {
+		if f.Severity != rules.SeverityReview || !reusable(f.Rule, f.Target) {
+			kept = append(kept, f)
+			continue
+		}
+
+		decision, err := c.findDecision(f.Target, f.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if decision == nil {
+			kept = append(kept, f)
+			continue
+		}
+
+		reason := fmt.Sprintf("link [%s] %s, reusing the decision made on approval %s", f.Target, decision.Status, decision.ApprovalID)
+
+		if decision.Status == "rejected" {
+			f.Severity = rules.SeverityReject
+			f.Reason = reason
+			kept = append(kept, f)
+			continue
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating ID: %w", err)
+		}
+
+		actions = append(actions, database.Action{
+			ID:       id.String(),
+			Status:   "approved",
+			Reason:   reason,
+			Target:   f.Target,
+			Hash:     f.Hash,
+			Decision: decision.ID,
+		})
+	}
+
+	return kept, actions, nil
+}
+
+// reusable reports whether a decision on a finding can be reused, only
+// decisions on links and images are remembered.
+func reusable(rule, target string) bool {
+	return rule == "links" && target != ""
+}
+
+// rememberDecision stores the decision made on an approval against the
+// link's url and the hash of its content.
+func (c *Controller) rememberDecision(tx database.Tx, approval *database.Approval, status string) error {
+
+	if !reusable(approval.Rule, approval.Target) {
+		return nil
+	}
+
+	keys := []string{urlKey(approval.Target)}
+	if approval.Hash != "" {
+		keys = append(keys, hashKey(approval.Hash))
+	}
+
+	for _, key := range keys {
+		decision := database.Decision{
+			ID:         decisionID(key),
+			Key:        key,
+			URL:        normalizeURL(approval.Target),
+			Hash:       approval.Hash,
+			Status:     status,
+			ApprovalID: approval.ID,
+			MessageID:  approval.MessageID,
+			Decided:    *approval.DecidedAt,
+			DecidedBy:  approval.DecidedBy,
+		}
+
+		jsonDecision, err := json.Marshal(decision)
+		if err != nil {
+			return fmt.Errorf("error encoding json: %w", err)
+		}
+
+		if err := tx.StoreDecision(decision.ID, jsonDecision); err != nil {
+			return fmt.Errorf("unable to store decision: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) AllDecisions(ctx *gin.Context) {
+
+	decisions, err := c.DB.GetAllDecisions()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"updated":   now,
+		"decisions": decisions,
+	})
+}
+
+// RevokeDecision forgets a remembered decision, along with the others made
+// on the same approval, so the link needs approving again.
+func (c *Controller) RevokeDecision(ctx *gin.Context) {
+
+	id := ctx.Param("id")
+
+	decision, err := c.DB.GetDecision(id)
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if decision == nil {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("decision does not exist"))
+		return
+	}
+
+	decisions, err := c.DB.GetAllDecisions()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	revoked := []string{}
+
+	for _, d := range decisions {
+		if d.ID != decision.ID && d.ApprovalID != decision.ApprovalID {
+			continue
+		}
+
+		if err := c.DB.DeleteDecision(d.ID); err != nil {
+			ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		revoked = append(revoked, d.ID)
+	}
+
+	c.log.WithField("approvalId", decision.ApprovalID).Infof("revoked decision on [%s]", decision.URL)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"revoked": revoked,
+	})
+}
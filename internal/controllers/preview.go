@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/database"
+)
+
+// previewRequest is a message sent for a dry run, it doesn't need an id as
+// nothing is stored.
+type previewRequest struct {
+	ID      string `json:"id"`
+	Body    string `json:"body" binding:"required"`
+	Channel string `json:"channel"`
+}
+
+// Preview runs the rules against a message and returns the decision that
+// would be made, along with every finding, without writing anything to the
+// database.
+func (c *Controller) Preview(ctx *gin.Context) {
+
+	var req previewRequest
+
+	if code, err := bindBody(ctx, &req, c.requests.MaxBytes); err != nil {
+		ctx.AbortWithError(code, err)
+		return
+	}
+
+	out := c.previewMessage(&database.Message{
+		ID:      req.ID,
+		Body:    req.Body,
+		Channel: req.Channel,
+	})
+	if out.err != nil {
+		abort(ctx, out)
+		return
+	}
+
+	ctx.JSON(out.code, out.body)
+}
+
+func (c *Controller) previewMessage(message *database.Message) outcome {
+
+	doc, findings := c.parse(message)
+	if len(findings) > 0 {
+		return invalidOutcome(findings)
+	}
+
+	findings, actions, err := c.evaluate(message, doc, true)
+	if err != nil {
+		return outcome{code: http.StatusInternalServerError, err: err}
+	}
+
+	reviews := applyFindings(message, findings, actions)
+
+	// the approvals that would be asked for, they have no id as they
+	// don't exist
+	for _, finding := range reviews {
+		message.Actions = append(message.Actions, database.Action{
+			Status: "pending",
+			Reason: finding.Reason,
+			Target: finding.Target,
+			Hash:   finding.Hash,
+		})
+	}
+
+	body := decisionBody(message, message.Status == "rejected", len(reviews) > 0)
+	body["dryRun"] = true
+	body["findings"] = findings
+	body["message"] = message
+
+	return outcome{code: http.StatusOK, body: body}
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/banned"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDryRun(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	// the banned list isn't loaded yet, so the first dry run fetches it
+	cache, err := banned.NewCache(ctrl.httpClient, ctrl.DB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.banned = cache
+
+	if err := ctrl.LoadRules(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	preview := func(target string, message interface{}) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+
+		ctx.Request = httptest.NewRequest(http.MethodPost, target, nil)
+		MockJsonPost(ctx, message)
+
+		if strings.HasPrefix(target, "/api/preview") {
+			ctrl.Preview(ctx)
+		} else {
+			ctrl.Validate(ctx)
+		}
+
+		result := map[string]interface{}{}
+		if w.Body.Len() > 0 {
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, result
+	}
+
+	code, result := preview("/api/validate?dryRun=true", map[string]string{
+		"body": "# Image\n\n![tower](https://example.com/tower.jpg) and [google](https://www.google.com)",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, result["dryRun"])
+	assert.Equal(t, "your message has has been rejected.", result["status"])
+	assert.Len(t, result["findings"], 2)
+
+	code, result = preview("/api/preview", database.Message{
+		ID:   "1",
+		Body: "# Image\n\n![tower](https://example.com/tower.jpg)",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "your message is awaiting approval as it contains image links.", result["status"])
+
+	actions := result["message"].(map[string]interface{})["actions"].([]interface{})
+	assert.Len(t, actions, 1)
+	assert.Equal(t, "pending", actions[0].(map[string]interface{})["status"])
+
+	code, _ = preview("/api/preview", map[string]string{"body": "No heading"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	messages, _ := ctrl.DB.GetAllMessages()
+	approvals, _ := ctrl.DB.GetAllApprovals()
+	rejected, _ := ctrl.DB.GetAllRejected()
+
+	assert.Len(t, messages, 0)
+	assert.Len(t, approvals, 0)
+	assert.Len(t, rejected, 0)
+
+	_, err = ctrl.DB.GetBannedList()
+	assert.Error(t, err)
+
+	// the id is still free
+	_, result = validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Image\n\n![tower](https://example.com/tower.jpg)",
+	})
+	assert.Equal(t, "your message is awaiting approval as it contains image links.", result["status"])
+}
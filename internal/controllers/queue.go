@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/markdown"
+)
+
+// ErrQueueFull is returned when a message can't be queued because the
+// queue is at its limit.
+var ErrQueueFull = errors.New("validation queue is full")
+
+type AsyncConfig struct {
+	Workers int
+	Queue   int
+}
+
+// queue holds the ids of messages waiting to be validated. The messages
+// themselves are stored with the status "queued" before they are added,
+// so the queue can be rebuilt from the database after a restart.
+type queue struct {
+	ids chan string
+
+	// mu makes the check for space and the add one step, so a request
+	// never has to wait for space once it has been accepted. Every send
+	// on ids happens under mu.
+	mu sync.Mutex
+	// backlog holds the requeued ids that didn't fit in ids after a
+	// restart, they are moved over as the workers make space.
+	backlog []string
+}
+
+// full reports whether there is no space for another id, the backlog
+// counts towards the limit. It must be called with mu held.
+func (q *queue) full() bool {
+	return len(q.ids)+len(q.backlog) >= cap(q.ids)
+}
+
+// refill moves ids from the backlog into the queue while there is space.
+// It must be called with mu held.
+func (q *queue) refill() {
+	for len(q.backlog) > 0 && len(q.ids) < cap(q.ids) {
+		q.ids <- q.backlog[0]
+		q.backlog = q.backlog[1:]
+	}
+}
+
+func newQueue(cfg *config.RawConfig) (*queue, int, error) {
+
+	asyncConfig := AsyncConfig{
+		Workers: 4,
+		Queue:   100,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&asyncConfig); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if asyncConfig.Workers < 1 {
+		return nil, 0, errors.New("async workers must be at least 1")
+	}
+
+	if asyncConfig.Queue < 1 {
+		return nil, 0, errors.New("async queue must be at least 1")
+	}
+
+	return &queue{
+		ids: make(chan string, asyncConfig.Queue),
+	}, asyncConfig.Workers, nil
+}
+
+// startWorkers starts the workers that validate queued messages, then
+// queues any messages left over from before a restart. It is called before
+// the server takes requests, so nothing else is queued while the messages
+// are loaded.
+func (c *Controller) startWorkers(workers int) {
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range c.queue.ids {
+				c.queue.mu.Lock()
+				c.queue.refill()
+				c.queue.mu.Unlock()
+
+				if err := c.validateQueued(id); err != nil {
+					c.log.WithField("messageId", id).Errorf("unable to validate queued message: %s", err)
+				}
+			}
+		}()
+	}
+
+	messages, err := c.DB.GetAllMessages()
+	if err != nil {
+		c.log.Errorf("unable to load queued messages: %s", err)
+		return
+	}
+
+	requeue := []string{}
+
+	for _, message := range messages {
+		// processing messages were cut off by the restart
+		if message.Status == "queued" || message.Status == "processing" {
+			requeue = append(requeue, message.ID)
+		}
+	}
+
+	if len(requeue) == 0 {
+		return
+	}
+
+	c.log.Infof("requeued %d messages for validation", len(requeue))
+
+	// there can be more than the queue holds
+	c.queue.mu.Lock()
+	c.queue.backlog = requeue
+	c.queue.refill()
+	c.queue.mu.Unlock()
+}
+
+// enqueue stores the message as queued and adds it to the queue.
+func (c *Controller) enqueue(message *database.Message) error {
+
+	c.queue.mu.Lock()
+	defer c.queue.mu.Unlock()
+
+	if c.queue.full() {
+		return ErrQueueFull
+	}
+
+	message.Status = "queued"
+
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	if err := c.DB.StoreMessage(message.ID, jsonMessage); err != nil {
+		return errors.New("unable to store message")
+	}
+
+	// there is space as nothing else sends while mu is held
+	c.queue.ids <- message.ID
+
+	return nil
+}
+
+// validateQueued runs the rules for a queued message. If it fails the
+// message is marked as failed so it doesn't stay queued forever.
+func (c *Controller) validateQueued(id string) error {
+
+	message, err := c.DB.GetMessage(id)
+	if err != nil {
+		return err
+	}
+
+	if message == nil || (message.Status != "queued" && message.Status != "processing") {
+		return nil
+	}
+
+	message.Status = "processing"
+
+	if err := c.updateMessage(message); err != nil {
+		return err
+	}
+
+	if _, _, err := c.handleValidation(message, markdown.Parse(message.Body)); err != nil {
+		if err := c.markFailed(message, err); err != nil {
+			return err
+		}
+
+		return err
+	}
+
+	c.log.WithField("messageId", id).Infof("queued message with ID [%s] is %s", id, message.Status)
+
+	return nil
+}
+
+// markFailed stores the message as failed with the error as its reason, so
+// it can be edited or looked into rather than waiting for good.
+func (c *Controller) markFailed(message *database.Message, err error) error {
+
+	message.Status = "failed"
+	message.Reason = fmt.Sprintf("message could not be validated: %s", err)
+
+	return c.updateMessage(message)
+}
+
+func (c *Controller) updateMessage(message *database.Message) error {
+
+	jsonMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	if err := c.DB.UpdateMessage(message.ID, jsonMessage); err != nil {
+		return errors.New("unable to store message")
+	}
+
+	return nil
+}
+
+// MessageStatus reports how far a message has got through validation.
+func (c *Controller) MessageStatus(ctx *gin.Context) {
+
+	id := ctx.Param("id")
+
+	message, _ := c.DB.GetMessage(id)
+	if message == nil {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("message does not exist"))
+		return
+	}
+
+	done := true
+	if message.Status == "queued" || message.Status == "processing" || message.Status == "pending" {
+		done = false
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":       message.ID,
+		"status":   message.Status,
+		"done":     done,
+		"reason":   message.Reason,
+		"tier":     message.Tier,
+		"actions":  message.Actions,
+		"findings": message.Findings,
+	})
+}
+
+func statusURL(id string) string {
+	return fmt.Sprintf("/api/messages/%s/status", url.PathEscape(id))
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func validateAsync(t *testing.T, ctrl *Controller, message interface{}) (int, map[string]interface{}, string) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate?async=true", nil)
+
+	MockJsonPost(ctx, message)
+
+	ctrl.Validate(ctx)
+
+	result := map[string]interface{}{}
+
+	if w.Body.Len() > 0 {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code, result, w.Header().Get("Location")
+}
+
+// waitForStatus polls the status endpoint until the message is done.
+func waitForStatus(t *testing.T, ctrl *Controller, id string) map[string]interface{} {
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+
+		ctx.Request = httptest.NewRequest(http.MethodGet, statusURL(id), nil)
+		ctx.Params = gin.Params{{Key: "id", Value: id}}
+
+		ctrl.MessageStatus(ctx)
+
+		result := map[string]interface{}{}
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+
+		if result["done"] == true {
+			return result
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("message %s was not validated in time", id)
+	return nil
+}
+
+func TestValidateAsync(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	// left queued by an earlier run
+	leftOver, err := json.Marshal(database.Message{
+		ID:     "0",
+		Body:   "# Left over\n\nThis talks about a flower.",
+		Status: "queued",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ctrl.DB.StoreMessage("0", leftOver); err != nil {
+		t.Fatal(err)
+	}
+
+	q, _, err := newQueue(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.queue = q
+	ctrl.startWorkers(2)
+
+	code, result, location := validateAsync(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Image\n\n![tower](https://example.com/tower.jpg)",
+	})
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "/api/messages/1/status", result["statusUrl"])
+	assert.Equal(t, "/api/messages/1/status", location)
+
+	status := waitForStatus(t, ctrl, "1")
+	assert.Equal(t, "awaiting approval", status["status"])
+
+	status = waitForStatus(t, ctrl, "0")
+	assert.Equal(t, "rejected", status["status"])
+
+	code, _ = validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Again\n\nSome text",
+	})
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestValidateAsyncQueueFull(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	// no workers, so nothing leaves the queue
+	q, _, err := newQueue(&config.RawConfig{"queue": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.queue = q
+
+	code, _, _ := validateAsync(t, ctrl, database.Message{ID: "1", Body: "# One\n\ntext"})
+	assert.Equal(t, http.StatusAccepted, code)
+
+	code, _, _ = validateAsync(t, ctrl, database.Message{ID: "2", Body: "# Two\n\ntext"})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestValidateAsyncBacklog(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	// left queued by an earlier run, more than the queue holds
+	for _, id := range []string{"0", "1", "2"} {
+		leftOver, err := json.Marshal(database.Message{ID: id, Body: "# Left over\n\ntext", Status: "queued"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ctrl.DB.StoreMessage(id, leftOver); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, _, err := newQueue(&config.RawConfig{"queue": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.queue = q
+
+	// no workers, so the backlog stays put
+	ctrl.startWorkers(0)
+
+	assert.Len(t, q.ids, 1)
+	assert.Len(t, q.backlog, 2)
+
+	code, _, _ := validateAsync(t, ctrl, database.Message{ID: "3", Body: "# Three\n\ntext"})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// taking an id makes space for the next one
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		seen[<-q.ids] = true
+
+		q.mu.Lock()
+		q.refill()
+		q.mu.Unlock()
+	}
+
+	assert.Equal(t, map[string]bool{"0": true, "1": true, "2": true}, seen)
+	assert.Len(t, q.ids, 0)
+	assert.Len(t, q.backlog, 0)
+
+	code, _, _ = validateAsync(t, ctrl, database.Message{ID: "3", Body: "# Three\n\ntext"})
+	assert.Equal(t, http.StatusAccepted, code)
+}
@@ -0,0 +1,569 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+)
+
+type RevalidateConfig struct {
+	// Rate is the most messages a job checks each second.
+	Rate int
+}
+
+// maxRevalidateRate keeps the interval between messages above zero.
+const maxRevalidateRate = 1000
+
+// jobs tracks the revalidation job that is running, only one runs at a
+// time so the rate limit holds.
+type jobs struct {
+	rate int
+
+	mu      sync.Mutex
+	running string
+}
+
+func newJobs(cfg *config.RawConfig) (*jobs, error) {
+
+	revalidateConfig := RevalidateConfig{
+		Rate: 10,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&revalidateConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if revalidateConfig.Rate < 1 || revalidateConfig.Rate > maxRevalidateRate {
+		return nil, fmt.Errorf("revalidate rate must be between 1 and %d", maxRevalidateRate)
+	}
+
+	return &jobs{
+		rate: revalidateConfig.Rate,
+	}, nil
+}
+
+type revalidateRequest struct {
+	Statuses []string  `json:"statuses"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	DryRun   bool      `json:"dryRun"`
+	Rate     int       `json:"rate"`
+}
+
+// statuses a message can be revalidated from, any other message is either
+// still being validated or can't get any worse.
+var revalidateStatuses = map[string]bool{
+	"validated":         true,
+	"awaiting approval": true,
+}
+
+// Revalidate starts a job that runs the current rules against the stored
+// messages, rejecting or sending for approval any that now break them.
+func (c *Controller) Revalidate(ctx *gin.Context) {
+
+	var req revalidateRequest
+
+	// every field is optional, so the body can be left out
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if len(req.Statuses) == 0 {
+		req.Statuses = []string{"validated"}
+	}
+
+	for _, status := range req.Statuses {
+		if !revalidateStatuses[status] {
+			ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("messages that are %q can't be revalidated", status))
+			return
+		}
+	}
+
+	if !req.From.IsZero() && !req.To.IsZero() && req.To.Before(req.From) {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("to must not be before from"))
+		return
+	}
+
+	if req.Rate == 0 {
+		req.Rate = c.jobs.rate
+	}
+
+	if req.Rate < 0 || req.Rate > c.jobs.rate {
+		ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("rate must be between 1 and %d", c.jobs.rate))
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("error generating ID: %w", err))
+		return
+	}
+
+	now := time.Now().UTC()
+
+	job := &database.Job{
+		ID:       id.String(),
+		Status:   "running",
+		Statuses: req.Statuses,
+		From:     req.From,
+		To:       req.To,
+		DryRun:   req.DryRun,
+		Rate:     req.Rate,
+		Started:  now,
+		Updated:  now,
+	}
+
+	if err := c.startJob(job); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrJobRunning) {
+			code = http.StatusConflict
+		}
+
+		ctx.AbortWithError(code, err)
+		return
+	}
+
+	ctx.Header("Location", jobURL(job.ID))
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ResumeRevalidation carries on with a job that failed from the last
+// message it checked.
+func (c *Controller) ResumeRevalidation(ctx *gin.Context) {
+
+	job, err := c.DB.GetJob(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if job == nil {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("job does not exist"))
+		return
+	}
+
+	if job.Status != "failed" {
+		ctx.AbortWithError(http.StatusConflict, fmt.Errorf("job is %s, only failed jobs can be resumed", job.Status))
+		return
+	}
+
+	job.Status = "running"
+	job.Error = ""
+
+	if err := c.startJob(job); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrJobRunning) {
+			code = http.StatusConflict
+		}
+
+		ctx.AbortWithError(code, err)
+		return
+	}
+
+	ctx.Header("Location", jobURL(job.ID))
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// RevalidationStatus reports the progress of a job.
+func (c *Controller) RevalidationStatus(ctx *gin.Context) {
+
+	job, err := c.DB.GetJob(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if job == nil {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("job does not exist"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+func (c *Controller) AllRevalidations(ctx *gin.Context) {
+
+	jobs, err := c.DB.GetAllJobs()
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Started.After(jobs[j].Started)
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"updated": time.Now().UTC(),
+		"jobs":    jobs,
+	})
+}
+
+// ErrJobRunning is returned when a job is started while another is running.
+var ErrJobRunning = errors.New("a revalidation job is already running")
+
+// startJob stores the job and runs it in the background.
+func (c *Controller) startJob(job *database.Job) error {
+
+	c.jobs.mu.Lock()
+	defer c.jobs.mu.Unlock()
+
+	if c.jobs.running != "" {
+		return ErrJobRunning
+	}
+
+	if err := c.storeJob(job); err != nil {
+		return err
+	}
+
+	c.jobs.running = job.ID
+
+	// the job runs on a copy so the caller can still read it
+	run := *job
+
+	go func() {
+		c.runJob(&run)
+
+		// stored under the lock so a new job can start as soon as this
+		// one shows as finished
+		c.jobs.mu.Lock()
+		defer c.jobs.mu.Unlock()
+
+		c.jobs.running = ""
+
+		if err := c.storeJob(&run); err != nil {
+			c.log.WithField("jobId", run.ID).Errorf("unable to store revalidation job: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// resumeJobs starts the job that was running when the service stopped.
+func (c *Controller) resumeJobs() {
+
+	jobs, err := c.DB.GetAllJobs()
+	if err != nil {
+		c.log.Errorf("unable to load revalidation jobs: %s", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if job.Status != "running" {
+			continue
+		}
+
+		if err := c.startJob(job); err != nil {
+			c.log.WithField("jobId", job.ID).Errorf("unable to resume revalidation job: %s", err)
+			continue
+		}
+
+		c.log.WithField("jobId", job.ID).Infof("resumed revalidation job from message [%s]", job.Cursor)
+	}
+}
+
+// runJob checks every message the job covers, in id order, at no more than
+// the job's rate. The job is stored after each message so it can be
+// resumed, the caller stores it once it has finished.
+func (c *Controller) runJob(job *database.Job) {
+
+	log := c.log.WithField("jobId", job.ID)
+
+	fail := func(err error) {
+		log.Errorf("revalidation job failed: %s", err)
+
+		job.Status = "failed"
+		job.Error = err.Error()
+	}
+
+	messages, err := c.DB.GetAllMessages()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	ids := []string{}
+	for _, message := range messages {
+		if jobCovers(job, message) {
+			ids = append(ids, message.ID)
+		}
+	}
+
+	sort.Strings(ids)
+
+	// messages that were checked before the job was resumed may no longer
+	// be covered, so only those left are added to what was processed
+	remaining := 0
+	for _, id := range ids {
+		if id > job.Cursor {
+			remaining++
+		}
+	}
+
+	job.Total = job.Processed + remaining
+
+	// a stored job can have a rate the config no longer allows
+	if job.Rate < 1 || job.Rate > c.jobs.rate {
+		job.Rate = c.jobs.rate
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(job.Rate))
+	defer ticker.Stop()
+
+	for _, id := range ids {
+		if id <= job.Cursor {
+			continue
+		}
+
+		<-ticker.C
+
+		// the message can have changed since the list was read
+		message, err := c.DB.GetMessage(id)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		if message != nil && jobCovers(job, message) {
+			change, err := c.revalidate(message, job.DryRun)
+			if err != nil {
+				fail(fmt.Errorf("message %s: %w", id, err))
+				return
+			}
+
+			if change != nil {
+				job.Changes = append(job.Changes, *change)
+			}
+		}
+
+		job.Processed++
+		job.Cursor = id
+
+		if err := c.storeJob(job); err != nil {
+			fail(fmt.Errorf("unable to store progress: %w", err))
+			return
+		}
+	}
+
+	job.Status = "done"
+
+	log.Infof("revalidated %d messages, %d changed", job.Processed, len(job.Changes))
+}
+
+// jobCovers reports whether the message matches the job's filters. Messages
+// stored before they had a created time only match jobs without a time
+// range.
+func jobCovers(job *database.Job, message *database.Message) bool {
+
+	matched := false
+	for _, status := range job.Statuses {
+		if message.Status == status {
+			matched = true
+		}
+	}
+
+	if !matched {
+		return false
+	}
+
+	if !job.From.IsZero() && message.Created.Before(job.From) {
+		return false
+	}
+
+	if !job.To.IsZero() && (message.Created.IsZero() || message.Created.After(job.To)) {
+		return false
+	}
+
+	return true
+}
+
+// revalidate runs the current rules against a stored message. Only changes
+// for the worse are made: a message that now breaks a rule is rejected, and
+// a validated message that now needs a review is sent for approval. It
+// returns nil when nothing changes.
+func (c *Controller) revalidate(message *database.Message, dryRun bool) (*database.Change, error) {
+
+	next := *message
+
+	findings, actions, err := c.evaluate(&next, markdown.Parse(message.Body), dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	findings = withoutApproved(findings, message.Actions)
+
+	reviews := applyFindings(&next, findings, actions)
+
+	rejected := next.Status == "rejected"
+	review := next.Status == "awaiting approval" && message.Status == "validated"
+
+	if !rejected && !review {
+		return nil, nil
+	}
+
+	change := &database.Change{
+		MessageID: message.ID,
+		From:      message.Status,
+		To:        next.Status,
+		Reason:    next.Reason,
+	}
+
+	if dryRun {
+		return change, nil
+	}
+
+	changed := false
+
+	// the rules are run outside the transaction as they can call out to
+	// other services, so the message is read again to make sure it is still
+	// the one that was checked
+	err = c.DB.Update(func(tx database.Tx) error {
+
+		changed = false
+
+		current, err := tx.GetMessage(message.ID)
+		if err != nil {
+			return err
+		}
+
+		if current == nil || current.Status != message.Status || current.Revision != message.Revision || current.Body != message.Body {
+			return nil
+		}
+
+		updated := next
+
+		// the actions already on the message are kept, along with the
+		// links approved by an earlier decision
+		updated.Actions = append([]database.Action{}, current.Actions...)
+
+		for _, act := range actions {
+			if !approved(updated.Actions, act.Target, act.Hash) {
+				updated.Actions = append(updated.Actions, act)
+			}
+		}
+
+		if rejected {
+			// pending approvals would bring the message back if approved
+			for i, act := range updated.Actions {
+				if act.Status != "pending" {
+					continue
+				}
+
+				if err := tx.DeleteApprovals(act.ID); err != nil {
+					return err
+				}
+
+				updated.Actions[i].Status = "cancelled"
+			}
+
+			jsonMessage, err := json.Marshal(updated)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.StoreReject(updated.ID, jsonMessage); err != nil {
+				return errors.New("unable to store rejected message")
+			}
+		}
+
+		for _, finding := range reviews {
+			act, err := c.requestApproval(tx, updated.ID, finding)
+			if err != nil {
+				return err
+			}
+
+			updated.Actions = append(updated.Actions, act)
+		}
+
+		jsonMessage, err := json.Marshal(updated)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.UpdateMessage(updated.ID, jsonMessage); err != nil {
+			return errors.New("unable to store message")
+		}
+
+		changed = true
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !changed {
+		c.log.WithField("messageId", message.ID).Infof("message with ID [%s] changed while it was revalidated, it is left as it is", message.ID)
+		return nil, nil
+	}
+
+	c.log.WithField("messageId", next.ID).Infof("revalidated message with ID [%s] is now %s", next.ID, next.Status)
+
+	return change, nil
+}
+
+// withoutApproved drops the review findings a moderator has already
+// approved on the message, matched by their target or the hash of the
+// image, so they aren't sent for approval again.
+func withoutApproved(findings []rules.Finding, actions []database.Action) []rules.Finding {
+
+	kept := []rules.Finding{}
+
+	for _, f := range findings {
+		if f.Severity == rules.SeverityReview && approved(actions, f.Target, f.Hash) {
+			continue
+		}
+
+		kept = append(kept, f)
+	}
+
+	return kept
+}
+
+// approved reports whether one of the actions approved the target or the
+// image with the hash.
+func approved(actions []database.Action, target, hash string) bool {
+
+	for _, act := range actions {
+		if act.Status != "approved" {
+			continue
+		}
+
+		if (target != "" && act.Target == target) || (hash != "" && act.Hash == hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Controller) storeJob(job *database.Job) error {
+
+	job.Updated = time.Now().UTC()
+
+	jsonJob, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return c.DB.StoreJob(job.ID, jsonJob)
+}
+
+func jobURL(id string) string {
+	return fmt.Sprintf("/api/admin/revalidate/%s", id)
+}
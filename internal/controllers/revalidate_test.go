@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func revalidate(t *testing.T, ctrl *Controller, req interface{}) (int, *database.Job) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/admin/revalidate", nil)
+
+	MockJsonPost(ctx, req)
+
+	ctrl.Revalidate(ctx)
+
+	if w.Code != http.StatusAccepted {
+		return w.Code, nil
+	}
+
+	job := &database.Job{}
+	if err := json.NewDecoder(w.Body).Decode(job); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, job
+}
+
+func waitForJob(t *testing.T, ctrl *Controller, id string) *database.Job {
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		job, err := ctrl.DB.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if job.Status != "running" {
+			return job
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not finish in time", id)
+	return nil
+}
+
+func TestRevalidate(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nhello world"})
+	validate(t, ctrl, database.Message{ID: "2", Body: "# Title\n\nnice day"})
+	validate(t, ctrl, database.Message{ID: "3", Body: "# Title\n\nsay hello ![cat](https://example.com/cat.png)"})
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "bannedwords", "terms": []interface{}{map[string]interface{}{"word": "hello"}}},
+		{"type": "links"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := revalidate(t, ctrl, gin.H{"statuses": []string{"rejected"}})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+
+	code, _ = revalidate(t, ctrl, gin.H{"rate": 5000})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+
+	// a dry run only lists the changes
+	code, job := revalidate(t, ctrl, gin.H{"dryRun": true, "statuses": []string{"validated", "awaiting approval"}})
+	assert.EqualValues(t, http.StatusAccepted, code)
+
+	job = waitForJob(t, ctrl, job.ID)
+	assert.Equal(t, "done", job.Status)
+	assert.Equal(t, 3, job.Total)
+	assert.Equal(t, 3, job.Processed)
+
+	if assert.Len(t, job.Changes, 2) {
+		assert.Equal(t, database.Change{MessageID: "1", From: "validated", To: "rejected", Reason: "message body contains banned word [hello]"}, job.Changes[0])
+		assert.Equal(t, "3", job.Changes[1].MessageID)
+	}
+
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.Equal(t, "validated", message.Status)
+
+	_, job = revalidate(t, ctrl, gin.H{"statuses": []string{"validated", "awaiting approval"}})
+	job = waitForJob(t, ctrl, job.ID)
+	assert.Len(t, job.Changes, 2)
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "rejected", message.Status)
+
+	message, _ = ctrl.DB.GetMessage("2")
+	assert.Equal(t, "validated", message.Status)
+
+	// the pending approval is cancelled so it can't bring the message back
+	message, _ = ctrl.DB.GetMessage("3")
+	assert.Equal(t, "rejected", message.Status)
+	assert.Equal(t, "cancelled", message.Actions[0].Status)
+
+	approvals, _ := ctrl.DB.GetAllApprovals()
+	assert.Len(t, approvals, 0)
+
+	rejected, _ := ctrl.DB.GetAllRejected()
+	assert.Len(t, rejected, 2)
+}
+
+func TestRevalidateKeepsApprovedImages(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, message.Actions[0].ID))
+
+	// without the remembered decision only the action shows it was approved
+	decisions, _ := ctrl.DB.GetAllDecisions()
+	if assert.NotEmpty(t, decisions) {
+		assert.EqualValues(t, http.StatusOK, decide(t, ctrl.RevokeDecision, decisions[0].ID))
+	}
+
+	decisions, _ = ctrl.DB.GetAllDecisions()
+	assert.Len(t, decisions, 0)
+
+	for i := 0; i < 2; i++ {
+		_, job := revalidate(t, ctrl, gin.H{})
+		job = waitForJob(t, ctrl, job.ID)
+		assert.Len(t, job.Changes, 0)
+	}
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "validated", message.Status)
+	assert.Len(t, message.Actions, 1)
+
+	// only the decided approval, nothing new was asked for
+	approvals, _ := ctrl.DB.GetAllApprovals()
+	if assert.Len(t, approvals, 1) {
+		assert.Equal(t, "approved", approvals[0].Status)
+	}
+}
+
+func TestRevalidateKeepsReusedDecisions(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, message.Actions[0].ID))
+
+	// every mock image has the same content, so only the decision on the
+	// cat's url is kept
+	decisions, _ := ctrl.DB.GetAllDecisions()
+	for _, decision := range decisions {
+		if strings.HasPrefix(decision.Key, "hash:") {
+			if err := ctrl.DB.DeleteDecision(decision.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// stored before images needed a review
+	if err := ctrl.LoadRules([]*config.RawConfig{{"type": "structure"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	validate(t, ctrl, database.Message{ID: "2", Body: "# Title\n\n![cat](https://example.com/cat.png) ![dog](https://example.com/dog.png)"})
+
+	if err := ctrl.LoadRules(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, job := revalidate(t, ctrl, gin.H{})
+	job = waitForJob(t, ctrl, job.ID)
+	assert.Len(t, job.Changes, 1)
+
+	// the cat was approved before so only the dog waits for a review
+	message, _ = ctrl.DB.GetMessage("2")
+	assert.Equal(t, "awaiting approval", message.Status)
+
+	if assert.Len(t, message.Actions, 2) {
+		assert.Equal(t, "https://example.com/cat.png", message.Actions[0].Target)
+		assert.Equal(t, "approved", message.Actions[0].Status)
+		assert.NotEmpty(t, message.Actions[0].Decision)
+
+		assert.Equal(t, "https://example.com/dog.png", message.Actions[1].Target)
+		assert.Equal(t, "pending", message.Actions[1].Status)
+	}
+
+	// once the dog is approved the message is validated
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, message.Actions[1].ID))
+
+	message, _ = ctrl.DB.GetMessage("2")
+	assert.Equal(t, "validated", message.Status)
+}
+
+func TestRevalidateRate(t *testing.T) {
+
+	_, err := newJobs(&config.RawConfig{"rate": 2000000000})
+	assert.Error(t, err)
+
+	_, err = newJobs(&config.RawConfig{"rate": 0})
+	assert.Error(t, err)
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nhello world"})
+
+	// a job stored with a rate that can't be used runs at the configured one
+	for _, rate := range []int{0, 2000000000} {
+		failed := &database.Job{
+			ID:       "job" + strconv.Itoa(rate),
+			Status:   "failed",
+			Statuses: []string{"validated"},
+			Rate:     rate,
+		}
+		if err := ctrl.storeJob(failed); err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(w)
+		ctx.Request = httptest.NewRequest(http.MethodPost, "/api/admin/revalidate/"+failed.ID+"/resume", nil)
+		ctx.Params = gin.Params{{Key: "id", Value: failed.ID}}
+
+		ctrl.ResumeRevalidation(ctx)
+		assert.EqualValues(t, http.StatusAccepted, w.Code)
+
+		job := waitForJob(t, ctrl, failed.ID)
+		assert.Equal(t, "done", job.Status)
+		assert.Equal(t, ctrl.jobs.rate, job.Rate)
+		assert.Equal(t, 1, job.Processed)
+	}
+}
+
+func TestRevalidateChangedMessage(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nhello world"})
+
+	stale, _ := ctrl.DB.GetMessage("1")
+
+	// the message is edited after the job read it
+	code, _ := edit(t, ctrl, "1", gin.H{"body": "# Title\n\ngoodbye world"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "bannedwords", "terms": []interface{}{map[string]interface{}{"word": "hello"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change, err := ctrl.revalidate(stale, false)
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.Equal(t, "validated", message.Status)
+	assert.Equal(t, "# Title\n\ngoodbye world", message.Body)
+
+	rejected, _ := ctrl.DB.GetAllRejected()
+	assert.Len(t, rejected, 0)
+}
+
+func TestRevalidateFilters(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nhello world"})
+
+	if err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "bannedwords", "terms": []interface{}{map[string]interface{}{"word": "hello"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, job := revalidate(t, ctrl, gin.H{"dryRun": true, "from": time.Now().Add(time.Hour)})
+	job = waitForJob(t, ctrl, job.ID)
+	assert.Equal(t, 0, job.Total)
+
+	_, job = revalidate(t, ctrl, gin.H{"dryRun": true, "from": time.Now().Add(-time.Hour), "to": time.Now()})
+	job = waitForJob(t, ctrl, job.ID)
+	assert.Len(t, job.Changes, 1)
+}
+
+func TestResumeRevalidation(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nhello world"})
+	validate(t, ctrl, database.Message{ID: "2", Body: "# Title\n\nhello again"})
+
+	if err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "bannedwords", "terms": []interface{}{map[string]interface{}{"word": "hello"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a job that failed after rejecting the first message
+	first, _ := ctrl.DB.GetMessage("1")
+	if _, err := ctrl.revalidate(first, false); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := &database.Job{
+		ID:        "job",
+		Status:    "failed",
+		Statuses:  []string{"validated"},
+		Rate:      100,
+		Processed: 1,
+		Cursor:    "1",
+	}
+	if err := ctrl.storeJob(failed); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/admin/revalidate/job/resume", nil)
+	ctx.Params = gin.Params{{Key: "id", Value: "job"}}
+
+	ctrl.ResumeRevalidation(ctx)
+	assert.EqualValues(t, http.StatusAccepted, w.Code)
+
+	job := waitForJob(t, ctrl, "job")
+	assert.Equal(t, "done", job.Status)
+	assert.Equal(t, 2, job.Processed)
+
+	// the first message is no longer covered but still counts
+	assert.Equal(t, 2, job.Total)
+
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.Equal(t, "rejected", message.Status)
+
+	message, _ = ctrl.DB.GetMessage("2")
+	assert.Equal(t, "rejected", message.Status)
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// editRequest is a new revision of a message, the channel is kept when it
+// isn't sent.
+type editRequest struct {
+	Body    string `json:"body" binding:"required"`
+	Channel string `json:"channel"`
+}
+
+// EditMessage replaces the body of a message with a new revision and
+// validates it. The earlier revision is kept along with what was decided
+// about it.
+func (c *Controller) EditMessage(ctx *gin.Context) {
+
+	var req editRequest
+
+	if code, err := bindBody(ctx, &req, c.requests.MaxBytes); err != nil {
+		ctx.AbortWithError(code, err)
+		return
+	}
+
+	message, err := c.DB.GetMessage(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if message == nil {
+		ctx.AbortWithError(http.StatusNotFound, errMessageNotFound)
+		return
+	}
+
+	if validating(message) {
+		ctx.AbortWithError(http.StatusConflict, errMessageValidating)
+		return
+	}
+
+	out := c.reviseMessage(message, req)
+	if out.err != nil {
+		abort(ctx, out)
+		return
+	}
+
+	ctx.JSON(out.code, out.body)
+}
+
+var (
+	errMessageNotFound   = errors.New("message does not exist")
+	errMessageValidating = errors.New("message is still being validated")
+	errMessageChanged    = errors.New("message was changed while it was being edited")
+)
+
+// validating reports whether the message is still being validated.
+func validating(message *database.Message) bool {
+	switch message.Status {
+	case "queued", "processing", "pending":
+		return true
+	}
+	return false
+}
+
+// reviseMessage stores the current revision of the message in its history
+// and validates the new one. The rules are run first, then everything is
+// written in one transaction against the message as it is stored, so a
+// failure or a decision made at the same time can't leave the revisions
+// and approvals out of step.
+func (c *Controller) reviseMessage(message *database.Message, req editRequest) outcome {
+
+	next := &database.Message{
+		ID:      message.ID,
+		Body:    req.Body,
+		Channel: req.Channel,
+	}
+
+	if next.Channel == "" {
+		next.Channel = message.Channel
+	}
+
+	doc, findings := c.parse(next)
+	if len(findings) > 0 {
+		return invalidOutcome(findings)
+	}
+
+	findings, actions, err := c.evaluate(next, doc, false)
+	if err != nil {
+		return outcome{code: http.StatusInternalServerError, err: err}
+	}
+
+	var (
+		revised                    database.Message
+		rejected, approvalRequired bool
+	)
+
+	err = c.DB.Update(func(tx database.Tx) error {
+
+		current, err := tx.GetMessage(message.ID)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			return errMessageNotFound
+		}
+
+		if validating(current) {
+			return errMessageValidating
+		}
+
+		if current.Revision != message.Revision {
+			return errMessageChanged
+		}
+
+		revised = *next
+
+		superseded, err := supersede(tx, current.Actions)
+		if err != nil {
+			return err
+		}
+
+		// messages from before revisions were kept are the first revision
+		revision := current.Revision
+		if revision == 0 {
+			revision = 1
+		}
+
+		now := time.Now().UTC()
+
+		revised.Revisions = append(current.Revisions, database.Revision{
+			Revision: revision,
+			Body:     current.Body,
+			Channel:  current.Channel,
+			Status:   current.Status,
+			Reason:   current.Reason,
+			Tier:     current.Tier,
+			Findings: current.Findings,
+			Actions:  superseded,
+			Created:  current.Created,
+			Replaced: &now,
+		})
+		revised.Revision = revision + 1
+		revised.Created = now
+
+		// the rejected messages only hold the current revision
+		if current.Status == "rejected" {
+			if err := tx.DeleteReject(current.ID); err != nil {
+				return errors.New("unable to remove rejected message")
+			}
+		}
+
+		rejected, approvalRequired, err = c.recordValidation(tx, &revised, findings, actions)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		return outcome{code: http.StatusNotFound, err: err}
+	case errors.Is(err, errMessageValidating), errors.Is(err, errMessageChanged):
+		return outcome{code: http.StatusConflict, err: err}
+	case err != nil:
+		c.log.WithField("messageId", message.ID).Errorf("unable to store revision: %s", err)
+		return outcome{code: http.StatusInternalServerError, err: err}
+	}
+
+	c.log.WithFields(logrus.Fields{"messageId": revised.ID, "revision": revised.Revision}).Infof("revision %d of message with ID [%s] is %s", revised.Revision, revised.ID, revised.Status)
+
+	body := decisionBody(&revised, rejected, approvalRequired)
+	body["revision"] = revised.Revision
+
+	return outcome{code: http.StatusOK, body: body}
+}
+
+// supersede closes the pending approvals of a revision that has been
+// replaced and returns its actions with them marked as superseded.
+func supersede(tx database.Tx, actions []database.Action) ([]database.Action, error) {
+
+	superseded := []database.Action{}
+
+	for _, act := range actions {
+		if act.Status == "pending" {
+			if err := supersedeApproval(tx, act.ID, ""); err != nil {
+				return nil, err
+			}
+
+			act.Status = "superseded"
+		}
+
+		superseded = append(superseded, act)
+	}
+
+	return superseded, nil
+}
+
+// MessageRevisions returns every revision of a message, oldest first, with
+// the current revision last.
+func (c *Controller) MessageRevisions(ctx *gin.Context) {
+
+	message, err := c.DB.GetMessage(ctx.Param("id"))
+	if err != nil {
+		ctx.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if message == nil {
+		ctx.AbortWithError(http.StatusNotFound, errors.New("message does not exist"))
+		return
+	}
+
+	revision := message.Revision
+	if revision == 0 {
+		revision = 1
+	}
+
+	revisions := append(message.Revisions, database.Revision{
+		Revision: revision,
+		Body:     message.Body,
+		Channel:  message.Channel,
+		Status:   message.Status,
+		Reason:   message.Reason,
+		Tier:     message.Tier,
+		Findings: message.Findings,
+		Actions:  message.Actions,
+		Created:  message.Created,
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"id":        message.ID,
+		"revision":  revision,
+		"revisions": revisions,
+	})
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func edit(t *testing.T, ctrl *Controller, id string, req interface{}) (int, map[string]interface{}) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPut, "/api/messages/"+id, nil)
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
+
+	MockJsonPost(ctx, req)
+	ctx.Request.Method = http.MethodPut
+
+	ctrl.EditMessage(ctx)
+
+	result := map[string]interface{}{}
+
+	if w.Body.Len() > 0 {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code, result
+}
+
+func revisions(t *testing.T, ctrl *Controller, id string) []database.Revision {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/api/messages/"+id+"/revisions", nil)
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
+
+	ctrl.MessageRevisions(ctx)
+
+	result := struct {
+		Revisions []database.Revision `json:"revisions"`
+	}{}
+
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	return result.Revisions
+}
+
+func TestEditMessage(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	_, result := validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nThis is adult content"})
+	assert.Equal(t, "your message has has been rejected.", result["status"])
+
+	code, _ := edit(t, ctrl, "2", gin.H{"body": "# Title\n\nhello"})
+	assert.EqualValues(t, http.StatusNotFound, code)
+
+	code, result = edit(t, ctrl, "1", gin.H{"body": "no heading"})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+	assert.NotNil(t, result["findings"])
+
+	code, result = edit(t, ctrl, "1", gin.H{"body": "# Title\n\nThis is fine content"})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "your message has been stored.", result["status"])
+	assert.EqualValues(t, 2, result["revision"])
+
+	// it is no longer a rejected message
+	rejected, _ := ctrl.DB.GetAllRejected()
+	assert.Len(t, rejected, 0)
+
+	// a rejected revision can be rejected again
+	code, _ = edit(t, ctrl, "1", gin.H{"body": "# Title\n\nadult again"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	code, _ = edit(t, ctrl, "1", gin.H{"body": "# Title\n\nstill adult"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	history := revisions(t, ctrl, "1")
+	if assert.Len(t, history, 4) {
+		assert.Equal(t, 1, history[0].Revision)
+		assert.Equal(t, "rejected", history[0].Status)
+		assert.Equal(t, "message body contains banned word [adult]", history[0].Reason)
+		assert.NotNil(t, history[0].Replaced)
+
+		assert.Equal(t, "# Title\n\nThis is fine content", history[1].Body)
+		assert.Equal(t, "validated", history[1].Status)
+
+		assert.Equal(t, 4, history[3].Revision)
+		assert.Equal(t, "rejected", history[3].Status)
+		assert.Nil(t, history[3].Replaced)
+	}
+}
+
+func TestEditMessageAwaitingApproval(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	old := message.Actions[0].ID
+
+	_, result := edit(t, ctrl, "1", gin.H{"body": "# Title\n\n![dog](https://example.com/dog.png)"})
+	assert.Equal(t, "your message is awaiting approval as it contains image links.", result["status"])
+
+	// the approval for the old image is closed, only the new one is pending
+	approvals, _ := ctrl.DB.GetAllApprovals()
+	if assert.Len(t, approvals, 2) {
+		for _, approval := range approvals {
+			if approval.ID == old {
+				assert.Equal(t, "superseded", approval.Status)
+			} else {
+				assert.Equal(t, "pending", approval.Status)
+				assert.Equal(t, "https://example.com/dog.png", approval.Target)
+			}
+		}
+	}
+
+	assert.EqualValues(t, http.StatusConflict, decide(t, ctrl.Approve, old))
+
+	history := revisions(t, ctrl, "1")
+	assert.Equal(t, "superseded", history[0].Actions[0].Status)
+	assert.Equal(t, "pending", history[1].Actions[0].Status)
+}
+
+func TestEditQueuedMessage(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	queued, _ := json.Marshal(database.Message{ID: "1", Body: "# Title\n\ntext", Status: "queued"})
+	if err := ctrl.DB.StoreMessage("1", queued); err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := edit(t, ctrl, "1", gin.H{"body": "# Title\n\nnew text"})
+	assert.EqualValues(t, http.StatusConflict, code)
+}
+
+func TestEditRollback(t *testing.T) {
+
+	ctrl := newTestController(t)
+	db := ctrl.DB
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := db.GetMessage("1")
+	id := message.Actions[0].ID
+
+	ctrl.DB = failingDB{db}
+
+	code, _ := edit(t, ctrl, "1", gin.H{"body": "# Title\n\nno images"})
+	assert.EqualValues(t, http.StatusInternalServerError, code)
+
+	// the message and its approval are left as they were
+	message, _ = db.GetMessage("1")
+	assert.Equal(t, "awaiting approval", message.Status)
+	assert.Equal(t, 1, message.Revision)
+	assert.Len(t, message.Revisions, 0)
+
+	approval, _ := db.GetApproval(id)
+	assert.Equal(t, "pending", approval.Status)
+
+	// an edit made from an older revision is refused
+	ctrl.DB = db
+	stale := *message
+
+	code, _ = edit(t, ctrl, "1", gin.H{"body": "# Title\n\nno images"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	out := ctrl.reviseMessage(&stale, editRequest{Body: "# Title\n\nolder edit"})
+	assert.EqualValues(t, http.StatusConflict, out.code)
+
+	message, _ = db.GetMessage("1")
+	assert.Equal(t, 2, message.Revision)
+	assert.Equal(t, "# Title\n\nno images", message.Body)
+}
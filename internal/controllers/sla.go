@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// slaReviewer is recorded as the reviewer of the default outcome.
+const slaReviewer = "sla"
+
+// outcomes maps the configured default outcome to the decision it makes.
+var outcomes = map[string]string{
+	"reject":  "rejected",
+	"approve": "approved",
+}
+
+// startSLA checks the pending approvals against the SLA every interval,
+// unless both the SLA and the deadline are turned off.
+func (c *Controller) startSLA() {
+
+	if c.approvals.SLA == 0 && c.approvals.Deadline == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.approvals.Interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			c.checkApprovals(now.UTC())
+		}
+	}()
+}
+
+// checkApprovals escalates the approvals that have waited longer than the
+// SLA and applies the default outcome to those past the deadline, oldest
+// first.
+func (c *Controller) checkApprovals(now time.Time) {
+
+	approvals, err := c.DB.GetAllApprovals()
+	if err != nil {
+		c.log.Errorf("unable to load approvals: %s", err)
+		return
+	}
+
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].CreatedAt.Before(approvals[j].CreatedAt)
+	})
+
+	for _, approval := range approvals {
+		if !pending(approval) {
+			continue
+		}
+
+		log := c.log.WithField("approvalId", approval.ID)
+
+		// approvals from before createdAt was kept start waiting now
+		if approval.CreatedAt.IsZero() {
+			if err := c.updateApproval(approval.ID, func(approval *database.Approval) bool {
+				if !approval.CreatedAt.IsZero() {
+					return false
+				}
+				approval.CreatedAt = now
+				return true
+			}); err != nil {
+				log.Errorf("unable to start the sla: %s", err)
+			}
+			continue
+		}
+
+		waiting := now.Sub(approval.CreatedAt)
+
+		if c.approvals.Deadline > 0 && waiting >= c.approvals.Deadline {
+			c.applyOutcome(approval)
+			continue
+		}
+
+		if c.approvals.SLA > 0 && waiting >= c.approvals.SLA && approval.EscalatedAt == nil {
+			c.escalate(approval, now)
+		}
+	}
+}
+
+// applyOutcome makes the default decision on an approval that nobody
+// decided on in time, in the same way a moderator would.
+func (c *Controller) applyOutcome(approval *database.Approval) {
+
+	status := outcomes[c.approvals.Outcome]
+
+	req := decisionRequest{
+		Reviewer: slaReviewer,
+		Comment:  fmt.Sprintf("no decision was made within %s, %s automatically", c.approvals.Deadline, status),
+	}
+
+	_, err := c.decideApproval(approval.ID, status, req)
+	switch {
+	case errors.Is(err, errApprovalNotFound), errors.Is(err, errApprovalClosed):
+		// decided on since the approvals were loaded
+	case errors.Is(err, errApprovalClaimed):
+		// left to the moderator while they hold it
+		c.log.WithField("approvalId", approval.ID).Infof("approval with ID [%s] is past the deadline but claimed by %s", approval.ID, approval.ClaimedBy)
+	case err != nil:
+		c.log.WithField("approvalId", approval.ID).Errorf("unable to apply the default outcome: %s", err)
+	}
+}
+
+// escalate raises the priority of an overdue approval and tells the
+// webhook about it. The webhook is called once, a failure is only logged.
+func (c *Controller) escalate(approval *database.Approval, now time.Time) {
+
+	log := c.log.WithFields(logrus.Fields{"approvalId": approval.ID, "messageId": approval.MessageID})
+
+	var escalated bool
+
+	err := c.updateApproval(approval.ID, func(stored *database.Approval) bool {
+		if stored.EscalatedAt != nil {
+			return false
+		}
+
+		stored.Priority = "high"
+		stored.EscalatedAt = &now
+
+		*approval = *stored
+		escalated = true
+
+		return true
+	})
+	if err != nil {
+		log.Errorf("unable to escalate approval: %s", err)
+		return
+	}
+
+	if !escalated {
+		return
+	}
+
+	log.Warnf("approval with ID [%s] has waited longer than %s and has been escalated", approval.ID, c.approvals.SLA)
+
+	if c.approvals.Webhook == "" {
+		return
+	}
+
+	if err := c.notify(approval, now.Sub(approval.CreatedAt)); err != nil {
+		log.Errorf("unable to notify the escalation webhook: %s", err)
+	}
+}
+
+// updateApproval changes a stored approval in a transaction, change reports
+// whether there is anything to store. A decided approval is left alone.
+func (c *Controller) updateApproval(id string, change func(*database.Approval) bool) error {
+
+	return c.DB.Update(func(tx database.Tx) error {
+
+		approval, err := tx.GetApproval(id)
+		if err != nil || approval == nil {
+			return err
+		}
+
+		if !pending(approval) || !change(approval) {
+			return nil
+		}
+
+		jsonApproval, err := json.Marshal(approval)
+		if err != nil {
+			return err
+		}
+
+		return tx.UpdateApprovals(approval.ID, jsonApproval)
+	})
+}
+
+type escalation struct {
+	Event    string             `json:"event"`
+	Approval *database.Approval `json:"approval"`
+	Waiting  string             `json:"waiting"`
+}
+
+func (c *Controller) notify(approval *database.Approval, waiting time.Duration) error {
+
+	body, err := json.Marshal(escalation{
+		Event:    "approval.escalated",
+		Approval: approval,
+		Waiting:  waiting.Round(time.Second).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding json: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.approvals.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.webhook.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", res.Status)
+	}
+
+	return nil
+}
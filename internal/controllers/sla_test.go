@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalSLA(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	ctrl.approvals.SLA = time.Hour
+	ctrl.approvals.Deadline = 2 * time.Hour
+	ctrl.approvals.Outcome = "approve"
+	ctrl.approvals.Webhook = "http://hooks.example.com/escalations"
+
+	escalations := []escalation{}
+	ctrl.webhook.SetTransport(&MockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		var sent escalation
+		if err := json.NewDecoder(req.Body).Decode(&sent); err != nil {
+			t.Fatal(err)
+		}
+		escalations = append(escalations, sent)
+
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+	}})
+
+	validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Title\n\n![cat](https://example.com/cat.png)\n\n![dog](https://example.com/dog.png)",
+	})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	cat, dog := message.Actions[0].ID, message.Actions[1].ID
+
+	approval, _ := ctrl.DB.GetApproval(cat)
+	assert.False(t, approval.CreatedAt.IsZero())
+	created := approval.CreatedAt
+
+	ctrl.checkApprovals(created.Add(30 * time.Minute))
+	assert.Len(t, escalations, 0)
+
+	ctrl.checkApprovals(created.Add(90 * time.Minute))
+	ctrl.checkApprovals(created.Add(100 * time.Minute))
+
+	// each approval is only escalated once
+	if assert.Len(t, escalations, 2) {
+		assert.Equal(t, "approval.escalated", escalations[0].Event)
+		assert.Equal(t, "high", escalations[0].Approval.Priority)
+	}
+
+	approval, _ = ctrl.DB.GetApproval(cat)
+	assert.Equal(t, "high", approval.Priority)
+	assert.NotNil(t, approval.EscalatedAt)
+
+	// a moderator still working on an approval keeps it past the deadline
+	code, _ := decideAs(t, ctrl.Claim, dog, "alice", nil)
+	assert.EqualValues(t, http.StatusOK, code)
+
+	ctrl.checkApprovals(created.Add(3 * time.Hour))
+
+	approval, _ = ctrl.DB.GetApproval(cat)
+	if assert.NotNil(t, approval) {
+		assert.Equal(t, "approved", approval.Status)
+		assert.Equal(t, slaReviewer, approval.DecidedBy)
+	}
+	approval, _ = ctrl.DB.GetApproval(dog)
+	if assert.NotNil(t, approval) {
+		assert.Equal(t, "pending", approval.Status)
+	}
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "approved", message.Actions[0].Status)
+	assert.Equal(t, slaReviewer, message.Actions[0].DecidedBy)
+	assert.Equal(t, "no decision was made within 2h0m0s, approved automatically", message.Actions[0].Comment)
+	assert.Equal(t, "pending", message.Actions[1].Status)
+}
+
+func TestApprovalDeadlineReject(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	ctrl.approvals.SLA = 0
+	ctrl.approvals.Deadline = time.Hour
+
+	validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+
+	message, _ := ctrl.DB.GetMessage("1")
+	approval, _ := ctrl.DB.GetApproval(message.Actions[0].ID)
+
+	ctrl.checkApprovals(approval.CreatedAt.Add(time.Hour))
+
+	message, _ = ctrl.DB.GetMessage("1")
+	assert.Equal(t, "rejected", message.Status)
+	assert.Equal(t, "rejected on review: no decision was made within 1h0m0s, rejected automatically", message.Reason)
+
+	rejected, _ := ctrl.DB.GetAllRejected()
+	assert.Len(t, rejected, 1)
+
+	// nobody looked at the image, so the outcome isn't reused
+	decisions, _ := ctrl.DB.GetAllDecisions()
+	assert.Len(t, decisions, 0)
+}
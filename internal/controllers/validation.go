@@ -1,100 +1,253 @@
 package controllers
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid"
 	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/kramllih/filterService/internal/rules/links"
 	"github.com/sirupsen/logrus"
 )
 
-const languageservice string = "/api/banned"
-
-var (
-	lvl1Heading = regexp.MustCompile(`(?:^|\s)(?:[#]\ )`)
-	links       = regexp.MustCompile(`\[(.*?)\]\((.*?)\)`)
-)
-
 func (c *Controller) Validate(ctx *gin.Context) {
 
 	var message database.Message
 
-	if err := ctx.ShouldBindJSON(&message); err != nil {
+	dryRun, err := queryBool(ctx, "dryRun")
+	if err != nil {
 		ctx.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
 
-	rdr := strings.NewReader(message.Body)
-
-	scanner := bufio.NewScanner(rdr)
-	scanner.Split(bufio.ScanLines)
+	if dryRun {
+		c.Preview(ctx)
+		return
+	}
 
-	var txtlines []string
+	async, err := queryBool(ctx, "async")
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
 
-	for scanner.Scan() {
-		text := scanner.Text()
-		if text == "" {
-			continue
-		}
-		txtlines = append(txtlines, strings.TrimSpace(text))
+	if code, err := bindBody(ctx, &message, c.requests.MaxBytes); err != nil {
+		ctx.AbortWithError(code, err)
+		return
 	}
 
-	if !lvl1Heading.MatchString(txtlines[0]) {
-		ctx.AbortWithError(http.StatusBadRequest, errors.New("first line must be a level 1 heading"))
+	out := c.validateMessage(&message, async)
+	if out.err != nil {
+		abort(ctx, out)
 		return
 	}
 
-	if len(txtlines) == 1 {
-		ctx.AbortWithError(http.StatusBadRequest, errors.New("body must contain at least 1 paragraph of text"))
+	if out.code == http.StatusAccepted {
+		ctx.Header("Location", statusURL(message.ID))
+	}
+
+	ctx.JSON(out.code, out.body)
+
+}
+
+// outcome is the response for a single message, err is set when the
+// message couldn't be validated. An error can come with a body giving
+// more detail.
+type outcome struct {
+	code int
+	body gin.H
+	err  error
+}
+
+// abort ends the request with the error from out, along with its body if
+// it has one.
+func abort(ctx *gin.Context, out outcome) {
+
+	if out.body == nil {
+		ctx.AbortWithError(out.code, out.err)
 		return
 	}
 
+	ctx.Error(out.err)
+
+	body := gin.H{"error": out.err.Error()}
+	for k, v := range out.body {
+		body[k] = v
+	}
+
+	ctx.AbortWithStatusJSON(out.code, body)
+}
+
+// invalidOutcome is the response for a message the checkers found to be
+// invalid.
+func invalidOutcome(findings []rules.Finding) outcome {
+	return outcome{
+		code: http.StatusBadRequest,
+		body: gin.H{"findings": findings},
+		err:  errors.New(joinReasons(findings, rules.SeverityInvalid)),
+	}
+}
+
+// validateMessage checks the structure of the message and then validates it,
+// or queues it when async is set.
+func (c *Controller) validateMessage(message *database.Message, async bool) outcome {
+
+	doc, findings := c.parse(message)
+	if len(findings) > 0 {
+		return invalidOutcome(findings)
+	}
+
+	// state is only ever set by the service
+	message.Actions = nil
+	message.Status = ""
+	message.Reason = ""
+	message.Findings = nil
+	message.Created = time.Now().UTC()
+	message.Revision = 1
+	message.Revisions = nil
+
 	mes, _ := c.DB.GetMessage(message.ID)
 
 	if mes != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, errors.New("id already exists"))
-		return
+		return outcome{code: http.StatusInternalServerError, err: errors.New("id already exists")}
 	}
 
-	rejected, approvalRequired, err := c.handleValidation(&message, txtlines)
+	if async {
+		if err := c.enqueue(message); err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, ErrQueueFull) {
+				code = http.StatusServiceUnavailable
+			}
+
+			return outcome{code: code, err: err}
+		}
+
+		c.log.WithField("messageId", message.ID).Infof("message with ID [%s] has been queued.", message.ID)
+
+		return outcome{code: http.StatusAccepted, body: gin.H{
+			"status":    "your message has been queued.",
+			"statusUrl": statusURL(message.ID),
+		}}
+	}
+
+	rejected, approvalRequired, err := c.handleValidation(message, doc)
 	if err != nil {
-		ctx.AbortWithError(http.StatusInternalServerError, err)
-		return
+		return outcome{code: http.StatusInternalServerError, err: err}
 	}
 
 	if approvalRequired {
 		c.log.WithField("messageId", message.ID).Infof("message with ID [%s] requires approval", message.ID)
-		ctx.JSON(http.StatusOK, gin.H{
-			"status": "your message is awaiting approval as it contains image links.",
-		})
-		return
-	}
-
-	if rejected {
+	} else if rejected {
 		c.log.WithFields(logrus.Fields{"messageId": message.ID, "reason": message.Reason}).Infof("message with ID [%s] has been rejected.", message.ID)
-		ctx.JSON(http.StatusOK, gin.H{
-			"status": "your message has has been rejected.",
-			"reason": message.Reason,
-		})
-		return
+	} else {
+		c.log.WithField("messageId", message.ID).Infof("message with ID [%s] has been validated.", message.ID)
 	}
 
-	c.log.WithField("messageId", message.ID).Infof("message with ID [%s] has been validated.", message.ID)
-	ctx.JSON(http.StatusOK, gin.H{
+	return outcome{code: http.StatusOK, body: decisionBody(message, rejected, approvalRequired)}
+}
+
+// decisionBody is the response telling the sender what was decided, along
+// with the findings that led to it.
+func decisionBody(message *database.Message, rejected, approvalRequired bool) gin.H {
+
+	body := gin.H{
 		"status": "your message has been stored.",
+	}
+
+	if approvalRequired {
+		body["status"] = "your message is awaiting approval."
+		if imagesOnly(message.Findings) {
+			body["status"] = "your message is awaiting approval as it contains image links."
+		}
+	} else if rejected {
+		body["status"] = "your message has has been rejected."
+		body["reason"] = message.Reason
+	}
+
+	if len(message.Findings) > 0 {
+		body["findings"] = message.Findings
+	}
+
+	return body
+}
+
+// parse returns the message body parsed as Markdown, or the findings that
+// stop the message being accepted, from the rules that are checkers. The
+// prechecks are run first, so a body that is too large is never parsed.
+func (c *Controller) parse(message *database.Message) (*markdown.Document, []rules.Finding) {
+
+	pipeline := c.rules()
+
+	if findings := pipeline.Precheck(&rules.Input{
+		Message: message,
+		Doc:     markdown.Unparsed(message.Body),
+	}); len(findings) > 0 {
+		return nil, findings
+	}
+
+	doc := markdown.Parse(message.Body)
+
+	return doc, pipeline.Check(&rules.Input{
+		Message: message,
+		Doc:     doc,
 	})
+}
+
+// bindBody decodes the JSON body of the request into v, reading no more
+// than max bytes of it. The returned code is the status to fail with.
+func bindBody(ctx *gin.Context, v interface{}, max int64) (int, error) {
 
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, max)
+
+	if err := ctx.ShouldBindJSON(v); err != nil {
+		// http.MaxBytesError needs a newer Go than the module's
+		if strings.Contains(err.Error(), "request body too large") {
+			return http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", max)
+		}
+
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
 }
 
-func (c *Controller) handleValidation(message *database.Message, txtlines []string) (bool, bool, error) {
+// queryBool reads a true or false query parameter, a missing parameter is
+// false.
+func queryBool(ctx *gin.Context, name string) (bool, error) {
+
+	value := ctx.Query(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q", name, value)
+	}
+
+	return b, nil
+}
+
+func (c *Controller) handleValidation(message *database.Message, doc *markdown.Document) (bool, bool, error) {
+
+	// the rules are run before the message is stored, so if they fail
+	// nothing is left behind and the message can be sent again
+	findings, actions, err := c.evaluate(message, doc, false)
+	if err != nil {
+		return false, false, err
+	}
+
+	stored := false
 
 	if message.Status == "" {
 		message.Status = "pending"
@@ -107,127 +260,165 @@ func (c *Controller) handleValidation(message *database.Message, txtlines []stri
 		if err := c.DB.StoreMessage(message.ID, jsonMessage); err != nil {
 			return false, false, errors.New("unable to store message")
 		}
+
+		stored = true
 	}
 
-	//handle reprocessed messages
-	if len(message.Actions) > 0 {
-		approved := 0
+	var (
+		validated                  database.Message
+		rejected, approvalRequired bool
+	)
+
+	err = c.DB.Update(func(tx database.Tx) error {
+		validated = *message
 
-		for _, action := range message.Actions {
-			if action.Status == "approved" {
-				approved++
+		var err error
+		rejected, approvalRequired, err = c.recordValidation(tx, &validated, findings, actions)
+		return err
+	})
+	if err != nil {
+		// so a new message isn't left pending for good, queued messages
+		// are marked by the worker
+		if stored {
+			if err := c.markFailed(message, err); err != nil {
+				c.log.WithField("messageId", message.ID).Errorf("unable to store failed message: %s", err)
 			}
 		}
 
-		if len(message.Actions) == approved {
-			message.Status = "validated"
+		return false, false, err
+	}
 
-			jsonMessage, err := json.Marshal(message)
-			if err != nil {
-				return false, false, err
-			}
+	*message = validated
 
-			if err := c.DB.UpdateMessage(message.ID, jsonMessage); err != nil {
-				return false, false, errors.New("unable to store message")
-			}
+	return rejected, approvalRequired, nil
+}
+
+// recordValidation applies the findings to a stored message and writes it,
+// along with its rejection or the approvals it needs, through tx. It
+// reports whether the message was rejected and whether it needs approval.
+func (c *Controller) recordValidation(tx database.Tx, message *database.Message, findings []rules.Finding, actions []database.Action) (bool, bool, error) {
 
-			return false, false, nil
+	reviews := applyFindings(message, findings, actions)
+	rejected := message.Status == "rejected"
+
+	if rejected {
+		jsonMessage, err := json.Marshal(message)
+		if err != nil {
+			return false, false, err
+		}
 
-		} else {
-			return false, false, nil
+		if err := tx.StoreReject(message.ID, jsonMessage); err != nil {
+			return false, false, errors.New("unable to store rejected message")
 		}
 	}
 
-	actions := []database.Action{}
-	approvalRequired := false
-	rejected := false
+	for _, finding := range reviews {
+		act, err := c.requestApproval(tx, message.ID, finding)
+		if err != nil {
+			return false, false, err
+		}
 
-	message.Status = "validated"
+		message.Actions = append(message.Actions, act)
+	}
 
-	banned, err := c.getBannedWords()
+	jsonMessage, err := json.Marshal(message)
 	if err != nil {
 		return false, false, err
 	}
 
-	for _, line := range txtlines[1:] {
+	if err := tx.UpdateMessage(message.ID, jsonMessage); err != nil {
+		return false, false, errors.New("unable to store message")
+	}
 
-		//checking banned words
-		matchedWords, err := c.checkAndHandleBannedWords(line, banned)
-		if err != nil {
-			return false, false, err
-		}
+	return rejected, len(reviews) > 0, nil
+}
 
-		if matchedWords != nil {
+// evaluate runs the rules against the message and reuses any earlier
+// decisions on its links. Nothing is written to the database, and for a dry
+// run the rules don't write anything either.
+func (c *Controller) evaluate(message *database.Message, doc *markdown.Document, dryRun bool) ([]rules.Finding, []database.Action, error) {
 
-			rejected = true
+	findings, err := c.rules().Evaluate(&rules.Input{
+		Message: message,
+		Doc:     doc,
+		DryRun:  dryRun,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-			message.Status = "rejected"
-			message.Reason = fmt.Sprintf("message body contains these banned words: [%v]", strings.Join(matchedWords, ","))
+	return c.applyDecisions(findings)
+}
 
-			jsonMessage, err := json.Marshal(message)
-			if err != nil {
-				return false, false, err
-			}
+// applyFindings sets the status, reason, tier, findings and actions of the
+// message and masks its body. It returns the findings that need an
+// approval, which is none when the message is rejected.
+func applyFindings(message *database.Message, findings []rules.Finding, actions []database.Action) []rules.Finding {
 
-			if err := c.DB.StoreReject(message.ID, jsonMessage); err != nil {
-				return false, false, errors.New("unable to store rejected message")
-			}
-			break
+	message.Status = "validated"
+	message.Actions = actions
+	message.Tier = rules.MostSevere(findings)
+	message.Findings = findings
+
+	// limits can change while a message is queued, so invalid findings
+	// reject the message rather than being lost
+	if message.Tier == rules.SeverityReject || message.Tier == rules.SeverityInvalid {
+		message.Status = "rejected"
+		message.Reason = joinReasons(findings, message.Tier)
+		return nil
+	}
 
-		}
+	message.Body = mask(message.Body, findings)
 
-		//checking links
-		if links.MatchString(line) {
-			act, required, reject, err := c.checkAndHandleLinks(line, message.ID)
-			if err != nil {
-				return false, false, err
-			}
+	reviews := []rules.Finding{}
 
-			if reject {
-				rejected = true
+	for _, finding := range findings {
+		if finding.Severity == rules.SeverityReview {
+			reviews = append(reviews, finding)
+		}
+	}
 
-				message.Status = "rejected"
-				message.Reason = "message body contains external links"
+	if len(reviews) > 0 {
+		message.Status = "awaiting approval"
+		message.Reason = "message requires approval"
 
-				jsonMessage, err := json.Marshal(message)
-				if err != nil {
-					return false, false, err
-				}
+		if imagesOnly(reviews) {
+			message.Reason = "message contains image that require approval"
+		}
+	}
 
-				if err := c.DB.StoreReject(message.ID, jsonMessage); err != nil {
-					return false, false, errors.New("unable to store rejected message")
-				}
-			}
+	return reviews
+}
 
-			if required {
-				approvalRequired = true
-			}
+// imagesOnly reports whether every finding that needs a review is for an
+// image.
+func imagesOnly(findings []rules.Finding) bool {
 
-			if act.ID != "" {
-				actions = append(actions, act)
-			}
+	for _, finding := range findings {
+		if finding.Severity == rules.SeverityReview && finding.Code != links.CodeImageReview {
+			return false
 		}
-
 	}
 
-	message.Actions = actions
+	return true
+}
 
-	if approvalRequired {
-		message.Status = "awaiting approval"
-		message.Reason = "message contains image that require approval"
-	}
+// joinReasons joins the distinct reasons of the findings with the severity.
+func joinReasons(findings []rules.Finding, severity string) string {
 
-	jsonMessage, err := json.Marshal(message)
-	if err != nil {
-		return false, false, err
-	}
+	reasons := []string{}
+	seen := map[string]bool{}
 
-	if err := c.DB.UpdateMessage(message.ID, jsonMessage); err != nil {
-		return false, false, errors.New("unable to store message")
-	}
+	for _, f := range findings {
+		if f.Severity != severity || seen[f.Reason] {
+			continue
+		}
+		seen[f.Reason] = true
 
-	return rejected, approvalRequired, nil
+		reasons = append(reasons, f.Reason)
+	}
 
+	return strings.Join(reasons, "; ")
 }
 
 func (c *Controller) Rejected(ctx *gin.Context) {
@@ -263,109 +454,74 @@ func (c *Controller) AllMessages(ctx *gin.Context) {
 	})
 }
 
-func (c *Controller) checkAndHandleLinks(line, messageID string) (database.Action, bool, bool, error) {
-
-	matches := links.FindStringSubmatch(line)
-	if strings.HasPrefix(strings.TrimSpace(matches[2]), "http") {
+// mask replaces every word covered by a mask finding with asterisks.
+func mask(body string, findings []rules.Finding) string {
 
-		ok, _ := isImage(strings.TrimSpace(matches[2]))
-		if !ok {
-			return database.Action{}, false, true, nil
-		}
-
-		id, err := uuid.NewV4()
-		if err != nil {
-			return database.Action{}, false, false, fmt.Errorf("error generating ID: %w", err)
+	masks := []rules.Finding{}
+	for _, f := range findings {
+		if f.Severity == rules.SeverityMask && f.Length > 0 {
+			masks = append(masks, f)
 		}
+	}
 
-		act := database.Action{
-			ID:     id.String(),
-			Status: "pending",
-			Reason: fmt.Sprintf("image [%s] requires approval", matches[2]),
-		}
-
-		approval := database.Approval{
-			ID:        id.String(),
-			Status:    "pending",
-			MessageID: messageID,
-			Reason:    fmt.Sprintf("image [%s] requires approval", matches[2]),
-		}
-
-		jsonApproval, err := json.Marshal(approval)
-		if err != nil {
-
-			return database.Action{}, false, false, fmt.Errorf("error encoding json: %w", err)
-		}
+	// work from the end so earlier offsets stay valid
+	sort.Slice(masks, func(i, j int) bool {
+		return masks[i].Offset > masks[j].Offset
+	})
 
-		if err := c.DB.StoreApproval(approval.ID, jsonApproval); err != nil {
+	last := -1
 
-			return database.Action{}, false, false, fmt.Errorf("unable to store message: %w", err)
+	for _, f := range masks {
+		end := f.Offset + f.Length
+		if f.Offset == last || f.Offset < 0 || end > len(body) {
+			continue
 		}
+		last = f.Offset
 
-		return act, true, false, nil
-
+		stars := strings.Repeat("*", utf8.RuneCountInString(body[f.Offset:end]))
+		body = body[:f.Offset] + stars + body[end:]
 	}
 
-	return database.Action{}, false, false, nil
+	return body
 }
 
-func isImage(url string) (bool, error) {
+// requestApproval stores a pending approval for the finding with tx, which
+// can be the database itself, and returns the matching action.
+func (c *Controller) requestApproval(tx database.Tx, messageID string, finding rules.Finding) (database.Action, error) {
 
-	res, err := http.Head(url)
+	id, err := uuid.NewV4()
 	if err != nil {
-		return false, fmt.Errorf("error access linked url: %w", err)
+		return database.Action{}, fmt.Errorf("error generating ID: %w", err)
 	}
-	contentType := res.Header["Content-Type"]
-	if strings.HasPrefix(contentType[0], "image") {
-		return true, nil
-	}
-
-	return false, nil
-}
 
-func (c *Controller) getBannedWords() ([]string, error) {
-
-	uri := c.httpClient.GetURI()
-	if !strings.HasSuffix(uri, "banned") {
-		c.httpClient.SetURI(c.httpClient.GetURI() + languageservice)
+	act := database.Action{
+		ID:     id.String(),
+		Status: "pending",
+		Reason: finding.Reason,
+		Target: finding.Target,
+		Hash:   finding.Hash,
 	}
 
-	res, err := c.httpClient.FetchContent()
-	if err != nil {
-		return nil, err
+	approval := database.Approval{
+		ID:        id.String(),
+		Status:    "pending",
+		MessageID: messageID,
+		Reason:    finding.Reason,
+		Target:    finding.Target,
+		Hash:      finding.Hash,
+		Rule:      finding.Rule,
+		Code:      finding.Code,
+		CreatedAt: time.Now().UTC(),
 	}
 
-	workResp := struct {
-		Updated time.Time
-		Words   []string
-	}{}
-
-	if err := json.Unmarshal(res, &workResp); err != nil {
-		return nil, err
-	}
-
-	return workResp.Words, nil
-}
-
-func (c *Controller) checkAndHandleBannedWords(line string, banned []string) ([]string, error) {
-
-	var matchedWords []string
-
-	words := strings.Fields(line)
-
-	for _, word := range words {
-		for _, bannedWord := range banned {
-			//if test := strings.Index(strings.ToLower(word), bannedWord); test > -1 { //used for sub matching rather than matching the whole word
-			if test := strings.EqualFold(strings.ToLower(word), bannedWord); test {
-				matchedWords = append(matchedWords, word)
-			}
-		}
+	jsonApproval, err := json.Marshal(approval)
+	if err != nil {
+		return database.Action{}, fmt.Errorf("error encoding json: %w", err)
 	}
 
-	if len(matchedWords) > 0 {
-		return matchedWords, nil
+	if err := tx.StoreApproval(approval.ID, jsonApproval); err != nil {
+		return database.Action{}, fmt.Errorf("unable to store message: %w", err)
 	}
 
-	return nil, nil
-
+	return act, nil
 }
@@ -3,28 +3,115 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/banned"
 	"github.com/kramllih/filterService/internal/database"
 	_ "github.com/kramllih/filterService/internal/database/mockdb"
 	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/kramllih/filterService/internal/imageprobe"
 	"github.com/kramllih/filterService/internal/logger"
+	_ "github.com/kramllih/filterService/internal/rules/bannedwords"
+	_ "github.com/kramllih/filterService/internal/rules/limits"
+	_ "github.com/kramllih/filterService/internal/rules/links"
+	_ "github.com/kramllih/filterService/internal/rules/structure"
 	"github.com/stretchr/testify/assert"
 )
 
 func mockController() *Controller {
 
-	return &Controller{
+	http := httpClient.MockHTTP()
+
+	cache, err := banned.NewCache(http, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	imageHTTP := httpClient.MockHTTP()
+
+	images, err := imageprobe.New(imageHTTP, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	imageHTTP.SetTransport(mockImageHost())
+
+	ctrl := &Controller{
 		log:        logger.NewLogger("controller"),
-		httpClient: httpClient.MockHTTP(),
+		httpClient: http,
+		banned:     cache,
+		images:     images,
+		webhook:    httpClient.MockHTTP(),
 	}
+
+	if ctrl.requests, err = newRequestsConfig(nil); err != nil {
+		panic(err)
+	}
+
+	if ctrl.batch, err = newBatchConfig(nil); err != nil {
+		panic(err)
+	}
+
+	ctrl.jobs = &jobs{rate: 1000}
+
+	if ctrl.approvals, err = newApprovalsConfig(nil); err != nil {
+		panic(err)
+	}
+
+	if err := ctrl.LoadRules(nil); err != nil {
+		panic(err)
+	}
+
+	return ctrl
+}
+
+// newTestController is mockController with the mock language service and
+// an empty database, which is what most tests start from.
+func newTestController(t *testing.T) *Controller {
+
+	ctrl := mockController()
+	ctrl.httpClient.SetTransport(mockLanguageService())
+	ctrl.DB = mockDB(t)
+
+	return ctrl
+}
+
+// mockImageHost serves a small gif for any url ending in an image
+// extension and a html page for everything else.
+func mockImageHost() *MockTransport {
+
+	mocktrans := &MockTransport{}
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+
+		body := "<!DOCTYPE html><html><body>page</body></html>"
+		contentType := "text/html"
+
+		switch strings.ToLower(path.Ext(req.URL.Path)) {
+		case ".jpg", ".jpeg", ".png", ".gif":
+			body = "GIF89a\x01\x00\x01\x00\x00\x00\x00;"
+			contentType = "image/gif"
+		}
+
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {contentType}},
+			ContentLength: int64(len(body)),
+			Body:          ioutil.NopCloser(strings.NewReader(body)),
+			Request:       req,
+		}, nil
+	}
+
+	return mocktrans
 }
 
 type MockTransport struct {
@@ -53,9 +140,7 @@ func TestValidateSimple(t *testing.T) {
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
 
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
 
 	message := database.Message{
 		ID: "1",
@@ -118,9 +203,7 @@ func TestValidateInternalLink(t *testing.T) {
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
 
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
 
 	message := database.Message{
 		ID: "1",
@@ -185,9 +268,7 @@ func TestValidateImageLink(t *testing.T) {
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
 
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
 
 	message := database.Message{
 		ID: "1",
@@ -248,9 +329,7 @@ func TestValidateExternalLink(t *testing.T) {
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
 
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
 
 	message := database.Message{
 		ID: "1",
@@ -311,9 +390,7 @@ func TestValidateBanned(t *testing.T) {
 	w := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(w)
 
-	ctx.Request = &http.Request{
-		Header: make(http.Header),
-	}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
 
 	message := database.Message{
 		ID: "1",
@@ -369,3 +446,429 @@ This message contains adult content`,
 	assert.Equal(t, "your message has has been rejected.", result["status"])
 
 }
+
+func mockDB(t *testing.T) database.Client {
+
+	cfg := config.RawConfig{
+		"database": map[string]interface{}{
+			"mockDB": map[string]interface{}{
+				"test": "test",
+			},
+		},
+	}
+
+	databaseCfg, err := config.UnpackNamespace("database", &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.Load(&databaseCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func mockLanguageService() *MockTransport {
+
+	mocktrans := &MockTransport{}
+	mocktrans.RoundTripFn = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"updated":"2022-06-15T19:17:58.3303721Z","words":["harmony","revolutionary","bounce","clue","auction","crew","question","flower","rescue","affair","think","night","morale","route","regular","veil","ensure","communication","undertake","gear","professional","judgment","adult","jaw","death","sex"]}`)),
+		}, nil
+	}
+
+	return mocktrans
+}
+
+func validate(t *testing.T, ctrl *Controller, message interface{}) (int, map[string]interface{}) {
+
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/api/validate", nil)
+
+	MockJsonPost(ctx, message)
+
+	ctrl.Validate(ctx)
+
+	result := map[string]interface{}{}
+
+	if w.Body.Len() > 0 {
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code, result
+}
+
+func TestValidateMarkdownVariants(t *testing.T) {
+
+	bodies := map[string]string{
+		"autolink":  "# Autolink\n\nSee <https://www.google.com>",
+		"reference": "# Reference\n\nSee [google][g]\n\n[g]: https://www.google.com",
+		"heading":   "# Heading [google](https://www.google.com)\n\nSome text",
+		"banned":    "Setext heading\n===\n\nThis is *adult* content",
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			ctrl := newTestController(t)
+
+			code, result := validate(t, ctrl, database.Message{ID: name, Body: body})
+			assert.EqualValues(t, http.StatusOK, code)
+			assert.Equal(t, "your message has has been rejected.", result["status"])
+		})
+	}
+}
+
+func TestValidateStructure(t *testing.T) {
+
+	bodies := map[string]string{
+		"no heading":   "Just some text\n\nand more",
+		"level 2":      "## Heading\n\nSome text",
+		"heading only": "# Heading",
+		"whitespace":   "   \n\n  ",
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			ctrl := newTestController(t)
+
+			code, _ := validate(t, ctrl, database.Message{ID: name, Body: body})
+			assert.EqualValues(t, http.StatusBadRequest, code)
+		})
+	}
+}
+
+func TestValidateStructureFindings(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	code, result := validate(t, ctrl, database.Message{ID: "1", Body: "# Heading"})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+	assert.Equal(t, "body must contain at least 1 paragraph of text", result["error"])
+
+	findings := result["findings"].([]interface{})
+	if assert.Len(t, findings, 1) {
+		assert.Equal(t, "MISSING_BODY", findings[0].(map[string]interface{})["code"])
+	}
+
+	_, result = validate(t, ctrl, database.Message{ID: "2", Body: "   "})
+	assert.Len(t, result["findings"], 2)
+}
+
+func TestValidateChannelStructure(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "structure", "profiles": map[string]interface{}{
+			"chat": map[string]interface{}{"heading": "none", "minBody": 1},
+		}},
+		{"type": "bannedwords"},
+		{"type": "links"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := validate(t, ctrl, database.Message{ID: "1", Body: "hello there", Channel: "chat"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	code, result := validate(t, ctrl, database.Message{ID: "2", Body: "# Hello\n\nthere", Channel: "chat"})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+	assert.Equal(t, "heading [Hello] is not allowed", result["error"])
+
+	// messages without a channel keep the default profile
+	code, _ = validate(t, ctrl, database.Message{ID: "3", Body: "hello there"})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+}
+
+func TestValidateLimits(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "limits", "maxLineLength": 40, "maxImages": 1},
+		{"type": "bannedwords"},
+		{"type": "links"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := "# Title\n\nthis line is much much too long for the limit\n\n![a](https://example.com/a.png)\n![b](https://example.com/b.png)"
+
+	code, result := validate(t, ctrl, database.Message{ID: "1", Body: body})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+	assert.Equal(t, "line 3 is 45 characters, the limit is 40; body has 2 images, the limit is 1", result["error"])
+	assert.Len(t, result["findings"], 2)
+
+	// nothing is stored so the id can be used again
+	message, _ := ctrl.DB.GetMessage("1")
+	assert.Nil(t, message)
+
+	code, _ = validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\nshort enough"})
+	assert.EqualValues(t, http.StatusOK, code)
+}
+
+func TestValidateRequestSize(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "limits", "maxBodyBytes": 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl.requests.MaxBytes = 512
+	ctrl.batch.MaxBytes = 512
+
+	// too large for the rule, but small enough to be read
+	code, result := validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n" + strings.Repeat("a", 200)})
+	assert.EqualValues(t, http.StatusBadRequest, code)
+	assert.Equal(t, "body is 209 bytes, the limit is 100", result["error"])
+
+	// too large to be read at all
+	code, _ = validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n" + strings.Repeat("a", 1000)})
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _ = validateBatch(t, ctrl, []database.Message{
+		{ID: "1", Body: "# Title\n\n" + strings.Repeat("a", 300)},
+		{ID: "2", Body: "# Title\n\n" + strings.Repeat("a", 300)},
+	})
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _ = edit(t, ctrl, "1", gin.H{"body": strings.Repeat("a", 1000)})
+	assert.EqualValues(t, http.StatusRequestEntityTooLarge, code)
+}
+
+func TestValidateFindings(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	body := "# Title\n\nThis is adult content\n\nsee [google](https://www.google.com) and more adult"
+
+	code, result := validate(t, ctrl, database.Message{ID: "1", Body: body})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "message body contains banned word [adult]; message body contains external links", result["reason"])
+
+	codes := map[string]int{}
+	for _, f := range result["findings"].([]interface{}) {
+		finding := f.(map[string]interface{})
+		codes[finding["code"].(string)]++
+
+		if finding["code"] == "EXTERNAL_LINK" {
+			assert.EqualValues(t, 5, finding["line"])
+			assert.EqualValues(t, 14, finding["column"])
+		}
+	}
+
+	assert.Equal(t, map[string]int{"BANNED_WORD": 2, "EXTERNAL_LINK": 1}, codes)
+
+	message, err := ctrl.DB.GetMessage("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, message.Findings, 3)
+}
+
+func TestValidateInternalThenExternalLink(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	code, result := validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Links\n\n[Section 1](#section1) and [google](https://www.google.com)",
+	})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "your message has has been rejected.", result["status"])
+}
+
+func TestValidateLanguageServiceDown(t *testing.T) {
+
+	ctrl := mockController()
+	ctrl.httpClient.SetTransport(&MockTransport{RoundTripFn: func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}})
+	ctrl.DB = mockDB(t)
+
+	code, result := validate(t, ctrl, database.Message{
+		ID:   "1",
+		Body: "# Simple Message\n\nThis is a simple message.",
+	})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "your message is awaiting approval.", result["status"])
+
+	approvals, err := ctrl.DB.GetAllApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, approvals, 1)
+}
+
+func TestValidateSeverityTiers(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{
+			"type":     "bannedwords",
+			"severity": "mask",
+			"terms": []interface{}{
+				map[string]interface{}{"word": "jaw", "severity": "review"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, result := validate(t, ctrl, database.Message{
+		ID:   "masked",
+		Body: "# Masked\n\nThis message contains Adult content",
+	})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "your message has been stored.", result["status"])
+
+	message, err := ctrl.DB.GetMessage("masked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "# Masked\n\nThis message contains ***** content", message.Body)
+	assert.Equal(t, "mask", message.Tier)
+	assert.Equal(t, "validated", message.Status)
+
+	// the masked word isn't given away by the findings either
+	stored, _ := json.Marshal(message)
+	response, _ := json.Marshal(result)
+	for _, leaked := range [][]byte{stored, response} {
+		assert.NotContains(t, strings.ToLower(string(leaked)), "adult")
+	}
+	if assert.Len(t, message.Findings, 1) {
+		assert.Empty(t, message.Findings[0].Target)
+		assert.Equal(t, len("# Masked\n\nThis message contains "), message.Findings[0].Offset)
+	}
+
+	// a word split up by markup is masked along with the markup
+	validate(t, ctrl, database.Message{ID: "split", Body: "# Split\n\nThis is ad*ult* content"})
+
+	message, _ = ctrl.DB.GetMessage("split")
+	assert.Equal(t, "# Split\n\nThis is ******* content", message.Body)
+
+	code, result = validate(t, ctrl, database.Message{
+		ID:   "review",
+		Body: "# Review\n\nThis message mentions a jaw",
+	})
+	assert.EqualValues(t, http.StatusOK, code)
+	assert.Equal(t, "your message is awaiting approval.", result["status"])
+
+	message, err = ctrl.DB.GetMessage("review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "review", message.Tier)
+	assert.Equal(t, "awaiting approval", message.Status)
+	assert.Equal(t, "message requires approval", message.Reason)
+	if !assert.Len(t, message.Actions, 1) {
+		return
+	}
+
+	// only decisions on links are reused, so nothing is remembered for a word
+	assert.EqualValues(t, http.StatusOK, decide(t, ctrl.Approve, message.Actions[0].ID))
+
+	decisions, _ := ctrl.DB.GetAllDecisions()
+	assert.Len(t, decisions, 0)
+}
+
+func TestValidateApprovalStatus(t *testing.T) {
+
+	ctrl := newTestController(t)
+
+	err := ctrl.LoadRules([]*config.RawConfig{
+		{"type": "links", "review": []interface{}{"example.org"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		body   string
+		status string
+		reason string
+	}{
+		{"# Image\n\n![tower](https://example.com/tower.jpg)", "your message is awaiting approval as it contains image links.", "message contains image that require approval"},
+		// a link to a review domain is not an image, even though it comes from the links rule
+		{"# Link\n\n[docs](https://example.org/docs)", "your message is awaiting approval.", "message requires approval"},
+		{"# Both\n\n![tower](https://example.com/tower.jpg) [docs](https://example.org/docs)", "your message is awaiting approval.", "message requires approval"},
+	}
+
+	for i, test := range tests {
+		id := strconv.Itoa(i)
+
+		code, result := validate(t, ctrl, database.Message{ID: id, Body: test.body})
+		assert.EqualValues(t, http.StatusOK, code)
+		assert.Equal(t, test.status, result["status"], test.body)
+
+		message, err := ctrl.DB.GetMessage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.reason, message.Reason, test.body)
+	}
+}
+
+// failingDB fails every message update made in a transaction.
+type failingDB struct {
+	database.Client
+}
+
+type failingTx struct {
+	database.Tx
+}
+
+func (f failingDB) Update(fn func(database.Tx) error) error {
+	return f.Client.Update(func(tx database.Tx) error {
+		return fn(failingTx{tx})
+	})
+}
+
+func (failingTx) UpdateMessage(id string, message []byte) error {
+	return errors.New("disk full")
+}
+
+func TestValidateStoreFailure(t *testing.T) {
+
+	ctrl := newTestController(t)
+	db := ctrl.DB
+	ctrl.DB = failingDB{db}
+
+	code, _ := validate(t, ctrl, database.Message{ID: "1", Body: "# Title\n\n![cat](https://example.com/cat.png)"})
+	assert.EqualValues(t, http.StatusInternalServerError, code)
+
+	// the message is marked failed rather than left pending
+	message, _ := db.GetMessage("1")
+	if !assert.NotNil(t, message) {
+		return
+	}
+	assert.Equal(t, "failed", message.Status)
+	assert.Equal(t, "message could not be validated: unable to store message", message.Reason)
+
+	approvals, _ := db.GetAllApprovals()
+	assert.Len(t, approvals, 0)
+
+	// so it can be edited once the database recovers
+	ctrl.DB = db
+
+	code, _ = edit(t, ctrl, "1", gin.H{"body": "# Title\n\nno images"})
+	assert.EqualValues(t, http.StatusOK, code)
+
+	message, _ = db.GetMessage("1")
+	assert.Equal(t, "validated", message.Status)
+}
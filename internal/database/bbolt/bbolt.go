@@ -15,6 +15,11 @@ const (
 	Approvals string = "approvals"
 	Rejected  string = "rejected"
 	Messages  string = "messages"
+	Banned    string = "banned"
+	Decisions string = "decisions"
+	Jobs      string = "jobs"
+
+	bannedListKey string = "list"
 )
 
 type bolt struct {
@@ -57,6 +62,21 @@ func NewDB(cfg *config.ConfigNamespace) (database.Client, error) {
 			return fmt.Errorf("create bucket: %s", err)
 		}
 
+		_, err = tx.CreateBucketIfNotExists([]byte(Banned))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(Decisions))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(Jobs))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+
 		return nil
 	})
 	if err != nil {
@@ -184,6 +204,22 @@ func (b *bolt) DeleteApprovals(id string) error {
 	return nil
 }
 
+func (b *bolt) DeleteReject(id string) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Rejected))
+		err := bu.Delete([]byte(id))
+		return err
+
+	})
+	if err != nil {
+		b.log.Errorf("Unable to delete reject from database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
 func (b *bolt) StoreReject(id string, message []byte) error {
 
 	err := b.DB.Update(func(tx *bbolt.Tx) error {
@@ -327,3 +363,225 @@ func (b *bolt) GetAllMessages() ([]*database.Message, error) {
 
 	return messages, nil
 }
+
+func (b *bolt) StoreBannedList(list []byte) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Banned))
+		err := bu.Put([]byte(bannedListKey), list)
+		return err
+
+	})
+	if err != nil {
+		b.log.Errorf("Unable to store banned list in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *bolt) GetBannedList() (*database.BannedList, error) {
+
+	var list *database.BannedList
+
+	err := b.DB.View(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Banned))
+		if bu == nil {
+			return errors.New("invalid bucket")
+		}
+
+		listBytes := bu.Get([]byte(bannedListKey))
+
+		if listBytes == nil {
+			return errors.New("no banned list in database")
+		}
+
+		err := json.Unmarshal(listBytes, &list)
+		if err != nil {
+			return fmt.Errorf("json unmarshal error: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get banned list from database: %s", err)
+	}
+
+	return list, nil
+}
+
+func (b *bolt) StoreDecision(id string, decision []byte) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Decisions))
+		err := bu.Put([]byte(id), decision)
+		return err
+
+	})
+	if err != nil {
+		b.log.Errorf("Unable to store decision in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *bolt) GetDecision(id string) (*database.Decision, error) {
+
+	var decision *database.Decision
+
+	err := b.DB.View(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Decisions))
+		if bu == nil {
+			return errors.New("invalid bucket")
+		}
+
+		decisionBytes := bu.Get([]byte(id))
+
+		if decisionBytes == nil {
+			return nil
+		}
+
+		err := json.Unmarshal(decisionBytes, &decision)
+		if err != nil {
+			return fmt.Errorf("json unmarshal error: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get decision from database: %s", err)
+	}
+
+	return decision, nil
+}
+
+func (b *bolt) GetAllDecisions() ([]*database.Decision, error) {
+
+	decisions := []*database.Decision{}
+
+	err := b.DB.View(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Decisions))
+		if bu == nil {
+			return errors.New("invalid bucket")
+		}
+
+		cursor := bu.Cursor()
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			d := database.Decision{}
+
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return fmt.Errorf("json unmarshal error: %w", err)
+			}
+
+			decisions = append(decisions, &d)
+
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
+
+func (b *bolt) DeleteDecision(id string) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Decisions))
+		err := bu.Delete([]byte(id))
+		return err
+
+	})
+	if err != nil {
+		b.log.Errorf("Unable to delete decision from database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *bolt) StoreJob(id string, job []byte) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Jobs))
+		err := bu.Put([]byte(id), job)
+		return err
+
+	})
+	if err != nil {
+		b.log.Errorf("Unable to store job in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *bolt) GetJob(id string) (*database.Job, error) {
+
+	var job *database.Job
+
+	err := b.DB.View(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Jobs))
+		if bu == nil {
+			return errors.New("invalid bucket")
+		}
+
+		jobBytes := bu.Get([]byte(id))
+
+		if jobBytes == nil {
+			return nil
+		}
+
+		err := json.Unmarshal(jobBytes, &job)
+		if err != nil {
+			return fmt.Errorf("json unmarshal error: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get job from database: %s", err)
+	}
+
+	return job, nil
+}
+
+func (b *bolt) GetAllJobs() ([]*database.Job, error) {
+
+	jobs := []*database.Job{}
+
+	err := b.DB.View(func(tx *bbolt.Tx) error {
+		bu := tx.Bucket([]byte(Jobs))
+		if bu == nil {
+			return errors.New("invalid bucket")
+		}
+
+		cursor := bu.Cursor()
+
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			d := database.Job{}
+
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return fmt.Errorf("json unmarshal error: %w", err)
+			}
+
+			jobs = append(jobs, &d)
+
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
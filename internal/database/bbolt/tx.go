@@ -0,0 +1,124 @@
+package bbolt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/kramllih/filterService/internal/database"
+	"go.etcd.io/bbolt"
+)
+
+// boltTx runs the database.Tx methods against one bbolt transaction. It
+// must not use the bolt client, bbolt only allows one writer at a time.
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+// Update runs fn in a single bbolt Update transaction, which is rolled back
+// if fn returns an error.
+func (b *bolt) Update(fn func(database.Tx) error) error {
+
+	err := b.DB.Update(func(tx *bbolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+	if err != nil {
+		b.log.Errorf("Transaction rolled back: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (t *boltTx) put(bucket, id string, value []byte) error {
+
+	bu := t.tx.Bucket([]byte(bucket))
+	if bu == nil {
+		return errors.New("invalid bucket")
+	}
+
+	return bu.Put([]byte(id), value)
+}
+
+func (t *boltTx) delete(bucket, id string) error {
+
+	bu := t.tx.Bucket([]byte(bucket))
+	if bu == nil {
+		return errors.New("invalid bucket")
+	}
+
+	return bu.Delete([]byte(id))
+}
+
+// get decodes the value stored under id into v, it reports false when
+// there is nothing stored.
+func (t *boltTx) get(bucket, id string, v interface{}) (bool, error) {
+
+	bu := t.tx.Bucket([]byte(bucket))
+	if bu == nil {
+		return false, errors.New("invalid bucket")
+	}
+
+	value := bu.Get([]byte(id))
+	if value == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		return false, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	return true, nil
+}
+
+func (t *boltTx) StoreApproval(id string, approval []byte) error {
+	return t.put(Approvals, id, approval)
+}
+
+func (t *boltTx) GetApproval(id string) (*database.Approval, error) {
+
+	approval := database.Approval{}
+
+	found, err := t.get(Approvals, id, &approval)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &approval, nil
+}
+
+func (t *boltTx) UpdateApprovals(id string, approval []byte) error {
+	return t.put(Approvals, id, approval)
+}
+
+func (t *boltTx) DeleteApprovals(id string) error {
+	return t.delete(Approvals, id)
+}
+
+func (t *boltTx) StoreReject(id string, message []byte) error {
+	return t.put(Rejected, id, message)
+}
+
+func (t *boltTx) DeleteReject(id string) error {
+	return t.delete(Rejected, id)
+}
+
+func (t *boltTx) GetMessage(id string) (*database.Message, error) {
+
+	message := database.Message{}
+
+	found, err := t.get(Messages, id, &message)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (t *boltTx) UpdateMessage(id string, message []byte) error {
+	return t.put(Messages, id, message)
+}
+
+func (t *boltTx) StoreDecision(id string, decision []byte) error {
+	return t.put(Decisions, id, decision)
+}
@@ -6,20 +6,53 @@ import (
 	"github.com/kramllih/filterService/config"
 )
 
-type Client interface {
+// Tx is the part of Client that can be used inside a transaction. The
+// methods behave the same as the Client methods of the same name.
+type Tx interface {
 	StoreApproval(string, []byte) error
 	GetApproval(string) (*Approval, error)
-	GetAllApprovals() ([]*Approval, error)
 	UpdateApprovals(string, []byte) error
 	DeleteApprovals(string) error
 
 	StoreReject(string, []byte) error
-	GetAllRejected() ([]*Message, error)
+	// DeleteReject removes a message from the rejected messages, it is
+	// not an error if the message isn't there.
+	DeleteReject(string) error
 
-	StoreMessage(string, []byte) error
 	GetMessage(string) (*Message, error)
 	UpdateMessage(string, []byte) error
+
+	// StoreDecision adds or replaces a decision.
+	StoreDecision(string, []byte) error
+}
+
+type Client interface {
+	Tx
+
+	// Update runs fn in a transaction, either every change fn makes is
+	// stored or, when it returns an error, none of them are.
+	Update(fn func(Tx) error) error
+
+	GetAllApprovals() ([]*Approval, error)
+
+	GetAllRejected() ([]*Message, error)
+
+	StoreMessage(string, []byte) error
 	GetAllMessages() ([]*Message, error)
+
+	StoreBannedList([]byte) error
+	GetBannedList() (*BannedList, error)
+
+	// GetDecision returns nil when there is no decision with the id.
+	GetDecision(string) (*Decision, error)
+	GetAllDecisions() ([]*Decision, error)
+	DeleteDecision(string) error
+
+	// StoreJob adds or replaces a job, GetJob returns nil when there is
+	// no job with the id.
+	StoreJob(string, []byte) error
+	GetJob(string) (*Job, error)
+	GetAllJobs() ([]*Job, error)
 }
 
 type Factory func(config *config.ConfigNamespace) (Client, error)
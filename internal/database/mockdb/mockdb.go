@@ -18,6 +18,9 @@ type mockClient struct {
 	Approvals map[string][]byte
 	Rejected  map[string][]byte
 	Messages  map[string][]byte
+	Banned    []byte
+	Decisions map[string][]byte
+	Jobs      map[string][]byte
 }
 
 func init() {
@@ -30,10 +33,51 @@ func NewDB(cfg *config.ConfigNamespace) (database.Client, error) {
 		Approvals: make(map[string][]byte),
 		Rejected:  make(map[string][]byte),
 		Messages:  make(map[string][]byte),
+		Decisions: make(map[string][]byte),
+		Jobs:      make(map[string][]byte),
 		log:       logger.NewLogger("mockDB"),
 	}, nil
 }
 
+// Update runs fn against a copy of the database, which replaces the
+// database only if fn succeeds. Other calls wait until it is done.
+func (m *mockClient) Update(fn func(database.Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &mockClient{
+		Approvals: clone(m.Approvals),
+		Rejected:  clone(m.Rejected),
+		Messages:  clone(m.Messages),
+		Banned:    m.Banned,
+		Decisions: clone(m.Decisions),
+		Jobs:      clone(m.Jobs),
+		log:       m.log,
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	m.Approvals = tx.Approvals
+	m.Rejected = tx.Rejected
+	m.Messages = tx.Messages
+	m.Decisions = tx.Decisions
+	m.Jobs = tx.Jobs
+
+	return nil
+}
+
+func clone(values map[string][]byte) map[string][]byte {
+
+	copied := make(map[string][]byte, len(values))
+	for k, v := range values {
+		copied[k] = v
+	}
+
+	return copied
+}
+
 func (m *mockClient) StoreApproval(id string, approval []byte) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -61,7 +105,7 @@ func (m *mockClient) GetApproval(id string) (*database.Approval, error) {
 		return &approval, nil
 	}
 
-	return nil, errors.New("id does not exist in database")
+	return nil, nil
 
 }
 func (m *mockClient) GetAllApprovals() ([]*database.Approval, error) {
@@ -99,13 +143,18 @@ func (m *mockClient) DeleteApprovals(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, ok := m.Approvals[id]; ok {
-		delete(m.Approvals, id)
-		return nil
-	}
+	delete(m.Approvals, id)
 
-	return errors.New("id does not exist in database")
+	return nil
+}
 
+func (m *mockClient) DeleteReject(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.Rejected, id)
+
+	return nil
 }
 
 func (m *mockClient) StoreReject(id string, reject []byte) error {
@@ -116,7 +165,7 @@ func (m *mockClient) StoreReject(id string, reject []byte) error {
 		return errors.New("id already exists in database")
 	}
 
-	m.Approvals[id] = reject
+	m.Rejected[id] = reject
 
 	return nil
 
@@ -168,7 +217,7 @@ func (m *mockClient) GetMessage(id string) (*database.Message, error) {
 		return &message, nil
 	}
 
-	return nil, errors.New("id does not exist in database")
+	return nil, nil
 }
 func (m *mockClient) UpdateMessage(id string, message []byte) error {
 	m.mu.Lock()
@@ -201,3 +250,135 @@ func (m *mockClient) GetAllMessages() ([]*database.Message, error) {
 
 	return messages, nil
 }
+
+func (m *mockClient) StoreBannedList(list []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Banned = list
+
+	return nil
+}
+
+func (m *mockClient) GetBannedList() (*database.BannedList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.Banned == nil {
+		return nil, errors.New("no banned list in database")
+	}
+
+	list := database.BannedList{}
+
+	if err := json.Unmarshal(m.Banned, &list); err != nil {
+		return nil, fmt.Errorf("error decoding data: %w", err)
+	}
+
+	return &list, nil
+}
+
+func (m *mockClient) StoreDecision(id string, decision []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Decisions[id] = decision
+
+	return nil
+}
+
+func (m *mockClient) GetDecision(id string) (*database.Decision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dec, ok := m.Decisions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	decision := database.Decision{}
+
+	if err := json.Unmarshal(dec, &decision); err != nil {
+		return nil, fmt.Errorf("error decoding data: %w", err)
+	}
+
+	return &decision, nil
+}
+
+func (m *mockClient) GetAllDecisions() ([]*database.Decision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	decisions := []*database.Decision{}
+
+	for _, v := range m.Decisions {
+		decision := database.Decision{}
+
+		if err := json.Unmarshal(v, &decision); err != nil {
+			return nil, fmt.Errorf("error decoding data: %w", err)
+		}
+
+		decisions = append(decisions, &decision)
+
+	}
+
+	return decisions, nil
+}
+
+func (m *mockClient) DeleteDecision(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.Decisions[id]; ok {
+		delete(m.Decisions, id)
+		return nil
+	}
+
+	return errors.New("id does not exist in database")
+}
+
+func (m *mockClient) StoreJob(id string, job []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Jobs[id] = job
+
+	return nil
+}
+
+func (m *mockClient) GetJob(id string) (*database.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	j, ok := m.Jobs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	job := database.Job{}
+
+	if err := json.Unmarshal(j, &job); err != nil {
+		return nil, fmt.Errorf("error decoding data: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (m *mockClient) GetAllJobs() ([]*database.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jobs := []*database.Job{}
+
+	for _, v := range m.Jobs {
+		job := database.Job{}
+
+		if err := json.Unmarshal(v, &job); err != nil {
+			return nil, fmt.Errorf("error decoding data: %w", err)
+		}
+
+		jobs = append(jobs, &job)
+
+	}
+
+	return jobs, nil
+}
@@ -1,17 +1,73 @@
 package database
 
+import "time"
+
 type Message struct {
-	ID      string   `json:"id" binding:"required"`
-	Body    string   `json:"body" binding:"required"`
-	Actions []Action `json:"actions,omitempty"`
-	Status  string   `json:"status"`
-	Reason  string   `json:"reasons,omitempty"`
+	ID       string    `json:"id" binding:"required"`
+	Body     string    `json:"body" binding:"required"`
+	Channel  string    `json:"channel,omitempty"`
+	Actions  []Action  `json:"actions,omitempty"`
+	Status   string    `json:"status"`
+	Reason   string    `json:"reasons,omitempty"`
+	Tier     string    `json:"tier,omitempty"`
+	Findings []Finding `json:"findings,omitempty"`
+	Created  time.Time `json:"created"`
+
+	// Revision counts from 1, Revisions holds the earlier revisions of
+	// the message, oldest first.
+	Revision  int        `json:"revision,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty"`
+}
+
+// Revision is an earlier body of a message along with what was decided
+// about it. Replaced is when the next revision was sent.
+type Revision struct {
+	Revision int        `json:"revision"`
+	Body     string     `json:"body"`
+	Channel  string     `json:"channel,omitempty"`
+	Status   string     `json:"status"`
+	Reason   string     `json:"reason,omitempty"`
+	Tier     string     `json:"tier,omitempty"`
+	Findings []Finding  `json:"findings,omitempty"`
+	Actions  []Action   `json:"actions,omitempty"`
+	Created  time.Time  `json:"created"`
+	Replaced *time.Time `json:"replaced,omitempty"`
+}
+
+// Finding is a single problem a rule found in a message. Code is a stable
+// name for the kind of problem and Target is the text that caused it. Line
+// and Column, both starting at 1, give where it starts in the body and
+// EndLine and EndColumn where it ends, they are 0 when the finding isn't
+// about one place. Mask findings cover the Length bytes of the body
+// starting at Offset. Hash is the hash of the content of a linked image.
+type Finding struct {
+	Rule      string `json:"rule"`
+	Code      string `json:"code"`
+	Severity  string `json:"severity"`
+	Reason    string `json:"reason"`
+	Target    string `json:"target,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Offset    int    `json:"offset,omitempty"`
+	Length    int    `json:"length,omitempty"`
+	Hash      string `json:"hash,omitempty"`
 }
 
 type Action struct {
-	ID     string `json:"id"`
-	Status string `json:"status"`
-	Reason string `json:"reason"`
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	Target   string `json:"target,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Decision string `json:"decision,omitempty"`
+	// SupersededBy is the approval whose rejection closed this action.
+	SupersededBy string     `json:"supersededBy,omitempty"`
+	DecidedBy    string     `json:"decidedBy,omitempty"`
+	DecidedAt    *time.Time `json:"decidedAt,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
 }
 
 type Approval struct {
@@ -19,4 +75,72 @@ type Approval struct {
 	Status    string `json:"status"`
 	MessageID string `json:"messageId"`
 	Reason    string `json:"reason"`
+	Target    string `json:"target,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	// the rule and code of the finding that needs approving
+	Rule      string    `json:"rule,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// set once the approval has waited longer than the SLA
+	Priority    string     `json:"priority,omitempty"`
+	EscalatedAt *time.Time `json:"escalatedAt,omitempty"`
+	// SupersededBy is the approval whose rejection closed this approval.
+	SupersededBy string `json:"supersededBy,omitempty"`
+	// the moderator working on the approval, until the lease expires
+	ClaimedBy    string     `json:"claimedBy,omitempty"`
+	ClaimExpires *time.Time `json:"claimExpires,omitempty"`
+	// the review, set once the approval has been decided
+	DecidedBy string     `json:"decidedBy,omitempty"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	Notes     string     `json:"notes,omitempty"`
+}
+
+// Decision is a moderator's decision on a link or image, remembered by its
+// normalised url or by the hash of its content so it can be reused.
+type Decision struct {
+	ID         string    `json:"id"`
+	Key        string    `json:"key"`
+	URL        string    `json:"url"`
+	Hash       string    `json:"hash,omitempty"`
+	Status     string    `json:"status"`
+	ApprovalID string    `json:"approvalId"`
+	MessageID  string    `json:"messageId"`
+	Decided    time.Time `json:"decided"`
+	DecidedBy  string    `json:"decidedBy,omitempty"`
+}
+
+// BannedList is the last banned word list fetched from the language service.
+type BannedList struct {
+	Updated time.Time `json:"updated"`
+	Fetched time.Time `json:"fetched"`
+	Words   []string  `json:"words"`
+}
+
+// Job is a revalidation of the stored messages. Cursor is the id of the
+// last message it checked, messages are checked in id order so a job can
+// carry on from there. Changes lists the messages whose status changed, or
+// would change for a dry run.
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Statuses  []string  `json:"statuses"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	DryRun    bool      `json:"dryRun"`
+	Rate      int       `json:"rate"`
+	Total     int       `json:"total"`
+	Processed int       `json:"processed"`
+	Cursor    string    `json:"cursor,omitempty"`
+	Changes   []Change  `json:"changes,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+type Change struct {
+	MessageID string `json:"messageId"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
 }
@@ -3,6 +3,8 @@ package mongodb
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/kramllih/filterService/config"
@@ -15,6 +17,8 @@ import (
 
 const (
 	dbName = "filter"
+
+	bannedListID = "list"
 )
 
 type mongoDb struct {
@@ -24,6 +28,9 @@ type mongoDb struct {
 	approvalCol *mongo.Collection
 	rejectedCol *mongo.Collection
 	messageCol  *mongo.Collection
+	bannedCol   *mongo.Collection
+	decisionCol *mongo.Collection
+	jobCol      *mongo.Collection
 }
 
 func init() {
@@ -54,6 +61,9 @@ func NewDB(cfg *config.ConfigNamespace) (database.Client, error) {
 	db.approvalCol = client.Database(dbName).Collection("approvals")
 	db.rejectedCol = client.Database(dbName).Collection("rejected")
 	db.messageCol = client.Database(dbName).Collection("messages")
+	db.bannedCol = client.Database(dbName).Collection("banned")
+	db.decisionCol = client.Database(dbName).Collection("decisions")
+	db.jobCol = client.Database(dbName).Collection("jobs")
 
 	return db, nil
 }
@@ -132,7 +142,7 @@ func (c *mongoDb) GetAllApprovals() ([]*database.Approval, error) {
 
 		err := cur.Decode(&result)
 		if err != nil {
-			c.log.Errorf("bson decode error: %w", err)
+			c.log.Errorf("bson decode error: %v", err)
 			break
 		}
 
@@ -184,6 +194,20 @@ func (c *mongoDb) DeleteApprovals(id string) error {
 	return nil
 }
 
+func (c *mongoDb) DeleteReject(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	if _, err := c.rejectedCol.DeleteOne(ctx, filter); err != nil {
+		c.log.Errorf("Unable to delete rejected message from database: %+v", err)
+		return err
+	}
+
+	return nil
+}
+
 func (c *mongoDb) StoreReject(id string, message []byte) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -229,7 +253,7 @@ func (c *mongoDb) GetAllRejected() ([]*database.Message, error) {
 
 		err := cur.Decode(&result)
 		if err != nil {
-			c.log.Errorf("bson decode error: %w", err)
+			c.log.Errorf("bson decode error: %v", err)
 			break
 		}
 
@@ -343,7 +367,7 @@ func (c *mongoDb) GetAllMessages() ([]*database.Message, error) {
 
 		err := cur.Decode(&result)
 		if err != nil {
-			c.log.Errorf("bson decode error: %w", err)
+			c.log.Errorf("bson decode error: %v", err)
 			break
 		}
 
@@ -361,3 +385,258 @@ func (c *mongoDb) GetAllMessages() ([]*database.Message, error) {
 
 	return messages, nil
 }
+
+func (c *mongoDb) StoreBannedList(list []byte) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": bannedListID}
+
+	data := bson.M{"_id": bannedListID, "message": list}
+
+	_, err := c.bannedCol.ReplaceOne(ctx, filter, data, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.log.Errorf("Unable to store banned list in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *mongoDb) GetBannedList() (*database.BannedList, error) {
+
+	type temp struct {
+		Id      string `bson:"_id"`
+		Message []byte `bson:"message"`
+	}
+
+	result := temp{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": bannedListID}
+
+	if err := c.bannedCol.FindOne(ctx, filter).Decode(&result); err != nil {
+		return nil, fmt.Errorf("unable to get banned list from database: %w", err)
+	}
+
+	if result.Message == nil {
+		return nil, errors.New("no banned list in database")
+	}
+
+	list := database.BannedList{}
+
+	if err := json.Unmarshal(result.Message, &list); err != nil {
+		return nil, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	return &list, nil
+}
+
+func (c *mongoDb) StoreDecision(id string, decision []byte) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	data := bson.M{"_id": id, "message": decision}
+
+	_, err := c.decisionCol.ReplaceOne(ctx, filter, data, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.log.Errorf("Unable to store decision in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *mongoDb) GetDecision(id string) (*database.Decision, error) {
+
+	type temp struct {
+		Id      string `bson:"_id"`
+		Message []byte `bson:"message"`
+	}
+
+	result := temp{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	if err := c.decisionCol.FindOne(ctx, filter).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get decision from database: %w", err)
+	}
+
+	decision := database.Decision{}
+
+	if err := json.Unmarshal(result.Message, &decision); err != nil {
+		return nil, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	return &decision, nil
+}
+
+func (c *mongoDb) GetAllDecisions() ([]*database.Decision, error) {
+
+	decisions := []*database.Decision{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := c.decisionCol.Find(ctx, bson.M{})
+	if err != nil {
+		c.log.Errorf("Unable to find decisions: %s", err)
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		d := database.Decision{}
+
+		type temp struct {
+			Id      string `bson:"_id"`
+			Message []byte `bson:"message"`
+		}
+
+		result := temp{}
+
+		err := cur.Decode(&result)
+		if err != nil {
+			c.log.Errorf("bson decode error: %v", err)
+			break
+		}
+
+		if result.Message != nil {
+			json.Unmarshal(result.Message, &d)
+
+			decisions = append(decisions, &d)
+		}
+
+	}
+	if err := cur.Err(); err != nil {
+		c.log.Errorf("Unable to get decisions: %+v", err)
+		return nil, err
+	}
+
+	return decisions, nil
+}
+
+func (c *mongoDb) DeleteDecision(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	res := c.decisionCol.FindOneAndDelete(ctx, filter)
+	if err := res.Err(); err != nil {
+		c.log.Errorf("Unable to delete decision: %+v", err)
+		return err
+
+	}
+
+	return nil
+}
+
+func (c *mongoDb) StoreJob(id string, job []byte) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	data := bson.M{"_id": id, "message": job}
+
+	_, err := c.jobCol.ReplaceOne(ctx, filter, data, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.log.Errorf("Unable to store job in database: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (c *mongoDb) GetJob(id string) (*database.Job, error) {
+
+	type temp struct {
+		Id      string `bson:"_id"`
+		Message []byte `bson:"message"`
+	}
+
+	result := temp{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+
+	if err := c.jobCol.FindOne(ctx, filter).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to get job from database: %w", err)
+	}
+
+	job := database.Job{}
+
+	if err := json.Unmarshal(result.Message, &job); err != nil {
+		return nil, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	return &job, nil
+}
+
+func (c *mongoDb) GetAllJobs() ([]*database.Job, error) {
+
+	jobs := []*database.Job{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cur, err := c.jobCol.Find(ctx, bson.M{})
+	if err != nil {
+		c.log.Errorf("Unable to find jobs: %s", err)
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+
+		d := database.Job{}
+
+		type temp struct {
+			Id      string `bson:"_id"`
+			Message []byte `bson:"message"`
+		}
+
+		result := temp{}
+
+		err := cur.Decode(&result)
+		if err != nil {
+			c.log.Errorf("bson decode error: %v", err)
+			break
+		}
+
+		if result.Message != nil {
+			json.Unmarshal(result.Message, &d)
+
+			jobs = append(jobs, &d)
+		}
+
+	}
+	if err := cur.Err(); err != nil {
+		c.log.Errorf("Unable to get jobs: %+v", err)
+		return nil, err
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,150 @@
+package mongodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kramllih/filterService/internal/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoTx runs the database.Tx methods in a session transaction, every
+// call must use the session context to be part of it.
+type mongoTx struct {
+	c   *mongoDb
+	ctx mongo.SessionContext
+}
+
+// Update runs fn in a session transaction. Transactions need MongoDB to be
+// running as a replica set. The driver retries fn if the transaction hits
+// a transient error, so fn must read what it changes through the Tx.
+func (c *mongoDb) Update(fn func(database.Tx) error) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := c.DB.StartSession()
+	if err != nil {
+		c.log.Errorf("Unable to start session: %s", err)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(&mongoTx{c: c, ctx: sc})
+	})
+	if err != nil {
+		c.log.Errorf("Transaction aborted: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+func (t *mongoTx) replace(col *mongo.Collection, id string, value []byte) error {
+
+	filter := bson.M{"_id": id}
+
+	data := bson.M{"_id": id, "message": value}
+
+	_, err := col.ReplaceOne(t.ctx, filter, data, options.Replace().SetUpsert(true))
+	return err
+}
+
+// get decodes the value stored under id into v, it reports false when
+// there is nothing stored.
+func (t *mongoTx) get(col *mongo.Collection, id string, v interface{}) (bool, error) {
+
+	type temp struct {
+		Id      string `bson:"_id"`
+		Message []byte `bson:"message"`
+	}
+
+	result := temp{}
+
+	filter := bson.M{"_id": id}
+
+	if err := col.FindOne(t.ctx, filter).Decode(&result); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := json.Unmarshal(result.Message, v); err != nil {
+		return false, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	return true, nil
+}
+
+func (t *mongoTx) StoreApproval(id string, approval []byte) error {
+
+	data := bson.M{"_id": id, "message": approval}
+
+	_, err := t.c.approvalCol.InsertOne(t.ctx, data)
+	return err
+}
+
+func (t *mongoTx) GetApproval(id string) (*database.Approval, error) {
+
+	approval := database.Approval{}
+
+	found, err := t.get(t.c.approvalCol, id, &approval)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &approval, nil
+}
+
+func (t *mongoTx) UpdateApprovals(id string, approval []byte) error {
+	return t.replace(t.c.approvalCol, id, approval)
+}
+
+// DeleteApprovals succeeds when the approval is already gone, in the same
+// way as the other drivers.
+func (t *mongoTx) DeleteApprovals(id string) error {
+
+	filter := bson.M{"_id": id}
+
+	_, err := t.c.approvalCol.DeleteOne(t.ctx, filter)
+	return err
+}
+
+func (t *mongoTx) StoreReject(id string, message []byte) error {
+	return t.replace(t.c.rejectedCol, id, message)
+}
+
+func (t *mongoTx) DeleteReject(id string) error {
+
+	filter := bson.M{"_id": id}
+
+	_, err := t.c.rejectedCol.DeleteOne(t.ctx, filter)
+	return err
+}
+
+func (t *mongoTx) GetMessage(id string) (*database.Message, error) {
+
+	message := database.Message{}
+
+	found, err := t.get(t.c.messageCol, id, &message)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+func (t *mongoTx) UpdateMessage(id string, message []byte) error {
+	return t.replace(t.c.messageCol, id, message)
+}
+
+func (t *mongoTx) StoreDecision(id string, decision []byte) error {
+	return t.replace(t.c.decisionCol, id, decision)
+}
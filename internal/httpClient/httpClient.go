@@ -2,14 +2,20 @@ package httpClient
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"syscall"
 	"time"
 )
 
+// ErrTooManyRedirects is returned when a request is redirected more times
+// than the limit set with SetMaxRedirects.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
 type HTTP struct {
 	client  *http.Client // HTTP client that is reused across requests.
 	headers map[string]string
@@ -48,6 +54,28 @@ func (h *HTTP) FetchResponse() (*http.Response, error) {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http request: %w", err)
+	}
+
+	return res, nil
+}
+
+// Do sends a request built by the caller, for callers that make requests
+// concurrently and so can't use the uri and method set on h.
+func (h *HTTP) Do(req *http.Request) (*http.Response, error) {
+
+	for k, v := range h.headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+
 	res, err := h.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error making http request: %w", err)
@@ -81,6 +109,21 @@ func (h *HTTP) SetBody(body []byte) {
 	h.body = body
 }
 
+// SetHeader sets a header sent with every request, an empty value removes
+// the header.
+func (h *HTTP) SetHeader(key, value string) {
+	if h.headers == nil {
+		h.headers = map[string]string{}
+	}
+
+	if value == "" {
+		delete(h.headers, key)
+		return
+	}
+
+	h.headers[key] = value
+}
+
 func (h *HTTP) GetURI() string {
 	return h.uri
 }
@@ -89,6 +132,33 @@ func (h *HTTP) SetURI(uri string) {
 	h.uri = uri
 }
 
+func (h *HTTP) SetTimeout(timeout time.Duration) {
+	h.client.Timeout = timeout
+}
+
+// SetMaxRedirects limits how many redirects are followed for a request.
+func (h *HTTP) SetMaxRedirects(max int) {
+	h.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > max {
+			return ErrTooManyRedirects
+		}
+		return nil
+	}
+}
+
+// SetDialControl replaces the transport with one that calls control for
+// every connection before it is made, after the address has been resolved.
+func (h *HTTP) SetDialControl(control func(network, address string, c syscall.RawConn) error) {
+	h.client.Transport = &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			DualStack: true,
+			Control:   control,
+		}).DialContext,
+	}
+}
+
 func (h *HTTP) SetTransport(tran http.RoundTripper) {
 	h.client.Transport = tran
 }
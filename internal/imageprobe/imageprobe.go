@@ -0,0 +1,419 @@
+package imageprobe
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/kramllih/filterService/internal/logger"
+)
+
+// Classes of error a probe can end with.
+const (
+	ClassInvalidURL string = "invalid_url"
+	ClassBlocked    string = "blocked"
+	ClassTimeout    string = "timeout"
+	ClassNetwork    string = "network"
+	ClassRedirects  string = "redirects"
+	ClassStatus     string = "status"
+	ClassNotImage   string = "not_image"
+)
+
+// ErrBlocked is returned for urls that resolve to a private, loopback or
+// link local address.
+var ErrBlocked = errors.New("address is not public")
+
+// sharedAddresses is the carrier grade NAT range, it isn't reachable from
+// the internet but net.IP doesn't count it as private.
+var sharedAddresses = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+var metrics = expvar.NewMap("imageProbe")
+
+type Config struct {
+	Timeout      time.Duration
+	MaxRedirects int
+	SniffBytes   int64
+	HashBytes    int64
+	AllowPrivate bool
+}
+
+// Result is what a probe found out about a url. Size is -1 when the server
+// didn't say, Width and Height are 0 when the image header couldn't be
+// decoded. Hash is the sha256 of an image's content, it is empty when the
+// image is bigger than HashBytes.
+type Result struct {
+	URL      string
+	MIME     string
+	Size     int64
+	Width    int
+	Height   int
+	Hash     string
+	ErrClass string
+	Err      error
+}
+
+// IsImage reports whether the content at the url is an image.
+func (r Result) IsImage() bool {
+	return r.ErrClass == "" && strings.HasPrefix(r.MIME, "image/")
+}
+
+// Prober checks whether urls point at images. The content type is always
+// sniffed from the first bytes of the content rather than taken from the
+// server.
+type Prober struct {
+	http *httpClient.HTTP
+	cfg  Config
+	log  *logger.Logger
+}
+
+// New creates a prober that makes its requests with http, cfg can be nil
+// to use the defaults.
+func New(http *httpClient.HTTP, cfg *config.RawConfig) (*Prober, error) {
+
+	probeConfig := Config{
+		Timeout:      5 * time.Second,
+		MaxRedirects: 3,
+		SniffBytes:   64 * 1024,
+		HashBytes:    10 * 1024 * 1024,
+	}
+
+	if cfg != nil {
+		if err := cfg.UnpackRaw(&probeConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if probeConfig.Timeout <= 0 {
+		return nil, errors.New("image probe timeout must be greater than 0")
+	}
+
+	if probeConfig.SniffBytes < 512 {
+		return nil, errors.New("image probe sniffBytes must be at least 512")
+	}
+
+	if probeConfig.MaxRedirects < 0 {
+		return nil, errors.New("image probe maxRedirects can't be negative")
+	}
+
+	http.SetTimeout(probeConfig.Timeout)
+	http.SetMaxRedirects(probeConfig.MaxRedirects)
+
+	if !probeConfig.AllowPrivate {
+		// checked when connecting so hostnames and redirects are covered
+		http.SetDialControl(checkDial)
+	}
+
+	return &Prober{
+		http: http,
+		cfg:  probeConfig,
+		log:  logger.NewLogger("imageprobe"),
+	}, nil
+}
+
+// Probe finds out what raw points at. A HEAD request is made first to
+// catch dead links and get the size, then the start of the content is
+// fetched with a ranged GET to sniff the type and dimensions. The requests
+// are cancelled when ctx is done.
+func (p *Prober) Probe(ctx context.Context, raw string) Result {
+
+	res := p.probe(ctx, raw)
+
+	class := res.ErrClass
+	if class == "" {
+		class = "ok"
+	}
+	metrics.Add(class, 1)
+
+	return res
+}
+
+func (p *Prober) probe(ctx context.Context, raw string) Result {
+
+	res := Result{
+		URL:  raw,
+		Size: -1,
+	}
+
+	u, err := p.target(raw)
+	if err != nil {
+		return fail(res, err)
+	}
+
+	declared := ""
+
+	// a server that doesn't support HEAD can fail it in any way, so
+	// anything other than a missing link still has its content fetched
+	head, err := p.request(ctx, http.MethodHead, u, nil)
+	if err == nil {
+		head.Body.Close()
+
+		switch head.StatusCode {
+		case http.StatusOK:
+			declared = mediaType(head.Header.Get("Content-Type"))
+			if head.ContentLength >= 0 {
+				res.Size = head.ContentLength
+			}
+		case http.StatusNotFound, http.StatusGone:
+			return fail(res, statusError(head))
+		}
+	}
+
+	get, err := p.request(ctx, http.MethodGet, u, http.Header{
+		"Range": {fmt.Sprintf("bytes=0-%d", p.cfg.SniffBytes-1)},
+	})
+	if err != nil {
+		return fail(res, err)
+	}
+	defer get.Body.Close()
+
+	switch get.StatusCode {
+	case http.StatusOK:
+		if get.ContentLength >= 0 {
+			res.Size = get.ContentLength
+		}
+	case http.StatusPartialContent:
+		if size, ok := rangeSize(get.Header.Get("Content-Range")); ok {
+			res.Size = size
+		}
+	default:
+		return fail(res, statusError(get))
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(get.Body, p.cfg.SniffBytes))
+	if err != nil {
+		return fail(res, err)
+	}
+
+	res.MIME = mediaType(http.DetectContentType(buf))
+
+	if !strings.HasPrefix(res.MIME, "image/") {
+		if strings.HasPrefix(declared, "image/") {
+			p.log.WithField("url", raw).Warnf("server said %s but content is %s", declared, res.MIME)
+		}
+
+		res.ErrClass = ClassNotImage
+		res.Err = fmt.Errorf("content is %s", res.MIME)
+		return res
+	}
+
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(buf)); err == nil {
+		res.Width = cfg.Width
+		res.Height = cfg.Height
+	}
+
+	res.Hash = p.hash(ctx, u, res.Size, buf, get)
+
+	return res
+}
+
+// hash returns the sha256 of the content at u. The bytes already read are
+// used when they are the whole content, or carried on from when the server
+// ignored the range, otherwise the content is fetched again. Nothing is
+// returned for content bigger than HashBytes.
+func (p *Prober) hash(ctx context.Context, u *url.URL, size int64, buf []byte, get *http.Response) string {
+
+	if size > p.cfg.HashBytes {
+		return ""
+	}
+
+	h := sha256.New()
+	var body io.Reader
+
+	switch {
+	case int64(len(buf)) == size:
+		body = bytes.NewReader(buf)
+
+	case get.StatusCode == http.StatusOK:
+		body = io.MultiReader(bytes.NewReader(buf), get.Body)
+
+	default:
+		res, err := p.request(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return ""
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return ""
+		}
+
+		body = res.Body
+	}
+
+	n, err := io.Copy(h, io.LimitReader(body, p.cfg.HashBytes+1))
+	if err != nil || n > p.cfg.HashBytes {
+		return ""
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// target parses raw and refuses anything that isn't a public http url. The
+// host is checked here as well as when dialling so literal addresses fail
+// without a request being made.
+func (p *Prober) target(raw string) (*url.URL, error) {
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, &classError{ClassInvalidURL, err}
+	}
+
+	// scheme relative links are fetched over https
+	if u.Scheme == "" && u.Host != "" {
+		u.Scheme = "https"
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.Hostname() == "" {
+		return nil, &classError{ClassInvalidURL, fmt.Errorf("%q is not an http url", raw)}
+	}
+
+	if p.cfg.AllowPrivate {
+		return u, nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return nil, ErrBlocked
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !public(ip) {
+		return nil, ErrBlocked
+	}
+
+	return u, nil
+}
+
+func (p *Prober) request(ctx context.Context, method string, u *url.URL, header http.Header) (*http.Response, error) {
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, &classError{ClassInvalidURL, err}
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	return p.http.Do(req)
+}
+
+// checkDial refuses connections to addresses that aren't public.
+func checkDial(network, address string, c syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !public(ip) {
+		return ErrBlocked
+	}
+
+	return nil
+}
+
+// public reports whether ip can be reached from the internet.
+func public(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() ||
+		sharedAddresses.Contains(ip))
+}
+
+// classError is an error with the class it should be reported as.
+type classError struct {
+	class string
+	err   error
+}
+
+func (e *classError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classError) Unwrap() error {
+	return e.err
+}
+
+func statusError(res *http.Response) error {
+	return &classError{ClassStatus, fmt.Errorf("HTTP error %d: %s", res.StatusCode, res.Status)}
+}
+
+// fail sets the error on res along with its class.
+func fail(res Result, err error) Result {
+
+	res.Err = err
+
+	var ce *classError
+	var ne net.Error
+
+	switch {
+	case errors.As(err, &ce):
+		res.ErrClass = ce.class
+	case errors.Is(err, ErrBlocked):
+		res.ErrClass = ClassBlocked
+	case errors.Is(err, httpClient.ErrTooManyRedirects):
+		res.ErrClass = ClassRedirects
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) && ne.Timeout():
+		res.ErrClass = ClassTimeout
+	default:
+		res.ErrClass = ClassNetwork
+	}
+
+	return res
+}
+
+// mediaType returns the type from a Content-Type header without any
+// parameters.
+func mediaType(contentType string) string {
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return mt
+}
+
+// rangeSize returns the full size from a Content-Range header such as
+// "bytes 0-511/1234".
+func rangeSize(contentRange string) (int64, bool) {
+
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, false
+	}
+
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return size, true
+}
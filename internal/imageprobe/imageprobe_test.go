@@ -0,0 +1,247 @@
+package imageprobe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kramllih/filterService/internal/httpClient"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTransport func(req *http.Request) (*http.Response, error)
+
+func (t mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t(req)
+}
+
+func mockProber(t *testing.T, tran mockTransport) *Prober {
+
+	http := httpClient.MockHTTP()
+
+	p, err := New(http, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	http.SetTransport(tran)
+
+	return p
+}
+
+func response(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": {contentType}},
+		ContentLength: int64(len(body)),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestProbeImage(t *testing.T) {
+
+	img := pngImage(t, 12, 7)
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		return response(req, http.StatusOK, "image/png", img), nil
+	})
+
+	res := p.Probe(context.Background(), "https://example.com/a.png")
+
+	assert.True(t, res.IsImage())
+	assert.Equal(t, "image/png", res.MIME)
+	assert.Equal(t, int64(len(img)), res.Size)
+	assert.Equal(t, 12, res.Width)
+	assert.Equal(t, 7, res.Height)
+}
+
+func TestProbeLyingServer(t *testing.T) {
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		return response(req, http.StatusOK, "image/png", []byte("<html><body>not an image</body></html>")), nil
+	})
+
+	res := p.Probe(context.Background(), "https://example.com/a.png")
+
+	assert.False(t, res.IsImage())
+	assert.Equal(t, ClassNotImage, res.ErrClass)
+	assert.Equal(t, "text/html", res.MIME)
+}
+
+func TestProbeNoHead(t *testing.T) {
+
+	img := pngImage(t, 3, 4)
+	ranges := []string{}
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			return response(req, http.StatusMethodNotAllowed, "", nil), nil
+		}
+
+		ranges = append(ranges, req.Header.Get("Range"))
+
+		if req.Header.Get("Range") == "" {
+			return response(req, http.StatusOK, "", img), nil
+		}
+
+		res := response(req, http.StatusPartialContent, "", img)
+		res.Header.Set("Content-Range", "bytes 0-65535/123456")
+		return res, nil
+	})
+
+	res := p.Probe(context.Background(), "https://example.com/image")
+
+	assert.True(t, res.IsImage())
+	assert.Equal(t, int64(123456), res.Size)
+	assert.NotEmpty(t, res.Hash)
+
+	// the whole image is only fetched again to hash it
+	assert.Equal(t, []string{"bytes=0-65535", ""}, ranges)
+}
+
+func TestProbeHeadFails(t *testing.T) {
+
+	img := pngImage(t, 3, 4)
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		if req.Method == http.MethodHead {
+			return nil, &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}
+		}
+		return response(req, http.StatusOK, "image/png", img), nil
+	})
+
+	res := p.Probe(context.Background(), "https://example.com/a.png")
+
+	assert.True(t, res.IsImage())
+	assert.Equal(t, int64(len(img)), res.Size)
+}
+
+func TestProbeContext(t *testing.T) {
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res := p.Probe(ctx, "https://example.com/a.png")
+
+	assert.Equal(t, ClassTimeout, res.ErrClass)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestProbeHash(t *testing.T) {
+
+	small := pngImage(t, 2, 2)
+	large := pngImage(t, 2, 3)
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/large.png" {
+			res := response(req, http.StatusOK, "image/png", large)
+			res.ContentLength = 20 * 1024 * 1024
+			return res, nil
+		}
+		return response(req, http.StatusOK, "image/png", small), nil
+	})
+
+	first := p.Probe(context.Background(), "https://example.com/a.png")
+	second := p.Probe(context.Background(), "https://other.example.com/b.png")
+
+	assert.Len(t, first.Hash, 64)
+	assert.Equal(t, first.Hash, second.Hash)
+	assert.Empty(t, p.Probe(context.Background(), "https://example.com/large.png").Hash)
+}
+
+func TestProbeMissingContentType(t *testing.T) {
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("plain text")),
+			Request:    req,
+		}, nil
+	})
+
+	res := p.Probe(context.Background(), "https://example.com/a")
+
+	assert.False(t, res.IsImage())
+	assert.Equal(t, ClassNotImage, res.ErrClass)
+}
+
+func TestProbeErrors(t *testing.T) {
+
+	p := mockProber(t, func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Host {
+		case "gone.example.com":
+			return response(req, http.StatusNotFound, "text/html", nil), nil
+		case "loop.example.com":
+			res := response(req, http.StatusFound, "", nil)
+			res.Header.Set("Location", "https://loop.example.com/again")
+			return res, nil
+		}
+		return nil, &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host"}}
+	})
+
+	cases := map[string]string{
+		"https://127.0.0.1/a.png":                  ClassBlocked,
+		"http://169.254.169.254/latest/meta-data/": ClassBlocked,
+		"http://10.0.0.1/a.png":                    ClassBlocked,
+		"http://[::1]/a.png":                       ClassBlocked,
+		"http://100.64.0.1/a.png":                  ClassBlocked,
+		"http://localhost/a.png":                   ClassBlocked,
+		"ftp://example.com/a.png":                  ClassInvalidURL,
+		"https://gone.example.com/a.png":           ClassStatus,
+		"https://loop.example.com/a.png":           ClassRedirects,
+		"https://down.example.com/a.png":           ClassNetwork,
+	}
+
+	for url, class := range cases {
+		res := p.Probe(context.Background(), url)
+		assert.Equal(t, class, res.ErrClass, url)
+		assert.Error(t, res.Err, url)
+	}
+}
+
+func TestCheckDial(t *testing.T) {
+
+	cases := map[string]bool{
+		"93.184.216.34:443":    true,
+		"[2606:4700::1]:443":   true,
+		"127.0.0.1:80":         false,
+		"192.168.1.10:80":      false,
+		"169.254.169.254:80":   false,
+		"[fe80::1]:80":         false,
+		"[::ffff:10.0.0.1]:80": false,
+		"0.0.0.0:80":           false,
+		"100.100.100.200:80":   false,
+		"100.128.0.1:443":      true,
+	}
+
+	for address, allowed := range cases {
+		err := checkDial("tcp", address, nil)
+		assert.Equal(t, allowed, err == nil, address)
+	}
+}
@@ -58,24 +58,21 @@ func NewLogger(service string) *Logger {
 	return log
 }
 
+// WithField returns a logger with the field added, l is left as it is so
+// it can be shared between goroutines.
 func (l *Logger) WithField(key string, value interface{}) *Logger {
-
-	l.fields[key] = value
-
-	entry := l.logger.WithFields(l.fields)
-
-	l.entry = entry
-
-	return l
+	return l.WithFields(logrus.Fields{key: value})
 }
 
 func (l *Logger) WithFields(fields logrus.Fields) *Logger {
 
 	newFields := mergeFields(l.fields, fields)
-	entry := l.logger.WithFields(newFields)
 
-	l.entry = entry
-	return l
+	return &Logger{
+		logger: l.logger,
+		entry:  l.logger.WithFields(newFields),
+		fields: newFields,
+	}
 }
 
 func (l *Logger) Info(args ...interface{}) {
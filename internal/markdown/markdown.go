@@ -0,0 +1,360 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+	xhtml "golang.org/x/net/html"
+)
+
+// Document is a message body parsed into a CommonMark AST, along with the
+// nodes the validation rules care about pulled out of it.
+type Document struct {
+	Source   []byte
+	Root     ast.Node
+	Headings []Heading
+	Links    []Link
+	Texts    []Text
+	Tags     []Tag
+
+	// run is the Run of the next text
+	run int
+}
+
+type Heading struct {
+	Level  int
+	Text   string
+	Offset int
+}
+
+type Link struct {
+	URL    string
+	Text   string
+	Image  bool
+	Offset int
+}
+
+// Tag is an opening tag found in raw HTML, Name is lower case.
+type Tag struct {
+	Name   string
+	Offset int
+}
+
+// Text is a run of text from the document, Offset and End are the byte
+// offsets of its start and end in Source. Entities are decoded, so Value
+// may not match Source byte for byte. Texts with the same Run read on from
+// each other, a new run starts at every block and at both ends of a link.
+type Text struct {
+	Value  string
+	Offset int
+	End    int
+	Run    int
+}
+
+// entity matches a character reference, along with anything that looks
+// like one but isn't, which is left as it is.
+var entity = regexp.MustCompile(`&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+
+// inlineTags are the HTML tags that don't start a new run of text.
+var inlineTags = map[string]bool{
+	"b": true, "i": true, "em": true, "strong": true, "u": true, "s": true,
+	"span": true, "small": true, "mark": true, "sub": true, "sup": true,
+	"code": true, "br": true,
+}
+
+var parser = goldmark.New().Parser()
+
+// Unparsed returns a document holding only the body, so it can be measured
+// and positions found in it before it is worth parsing.
+func Unparsed(body string) *Document {
+	return &Document{Source: []byte(body)}
+}
+
+func Parse(body string) *Document {
+
+	source := []byte(body)
+
+	doc := &Document{
+		Source: source,
+		Root:   parser.Parse(text.NewReader(source)),
+	}
+
+	ast.Walk(doc.Root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+
+		switch n.(type) {
+		case *ast.Link, *ast.Image, *ast.AutoLink:
+			doc.run++
+		default:
+			if entering && n.Type() == ast.TypeBlock {
+				doc.run++
+			}
+		}
+
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch node := n.(type) {
+		case *ast.Heading:
+			doc.Headings = append(doc.Headings, Heading{
+				Level:  node.Level,
+				Text:   string(node.Text(source)),
+				Offset: offset(node, source),
+			})
+
+		case *ast.Link:
+			doc.Links = append(doc.Links, Link{
+				URL:    string(node.Destination),
+				Text:   string(node.Text(source)),
+				Offset: offset(node, source),
+			})
+
+		case *ast.Image:
+			doc.Links = append(doc.Links, Link{
+				URL:    string(node.Destination),
+				Text:   string(node.Text(source)),
+				Image:  true,
+				Offset: offset(node, source),
+			})
+
+		case *ast.AutoLink:
+			url := string(node.URL(source))
+			doc.Links = append(doc.Links, Link{
+				URL:    url,
+				Text:   url,
+				Offset: offset(node, source),
+			})
+
+		case *ast.Text:
+			// entities aren't decoded in code
+			if _, code := node.Parent().(*ast.CodeSpan); code {
+				doc.Texts = append(doc.Texts, Text{
+					Value:  string(node.Segment.Value(source)),
+					Offset: node.Segment.Start,
+					End:    node.Segment.Stop,
+					Run:    doc.run,
+				})
+				break
+			}
+
+			doc.addText(node.Segment.Value(source), node.Segment.Start)
+
+		case *ast.String:
+			start := offset(node, source)
+			doc.Texts = append(doc.Texts, Text{
+				Value:  string(node.Value),
+				Offset: start,
+				End:    start + len(node.Value),
+				Run:    doc.run,
+			})
+
+		case *ast.RawHTML:
+			for i := 0; i < node.Segments.Len(); i++ {
+				seg := node.Segments.At(i)
+				doc.parseHTML(seg.Value(source), seg.Start)
+			}
+
+		case *ast.HTMLBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				seg := lines.At(i)
+				doc.parseHTML(seg.Value(source), seg.Start)
+			}
+
+			if node.HasClosure() {
+				doc.parseHTML(node.ClosureLine.Value(source), node.ClosureLine.Start)
+			}
+
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			lines := node.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				seg := lines.At(i)
+				doc.Texts = append(doc.Texts, Text{
+					Value:  string(seg.Value(source)),
+					Offset: seg.Start,
+					End:    seg.Stop,
+					Run:    doc.run,
+				})
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	return doc
+}
+
+// addText adds Markdown text starting at start in Source. Each entity is
+// decoded into a text of its own, so every text either matches Source byte
+// for byte or is a single entity.
+func (d *Document) addText(raw []byte, start int) {
+
+	last := 0
+
+	add := func(from, to int, value string) {
+		if value == "" {
+			return
+		}
+		d.Texts = append(d.Texts, Text{
+			Value:  value,
+			Offset: start + from,
+			End:    start + to,
+			Run:    d.run,
+		})
+	}
+
+	for _, loc := range entity.FindAllIndex(raw, -1) {
+		ref := string(raw[loc[0]:loc[1]])
+
+		decoded := html.UnescapeString(ref)
+		if decoded == ref {
+			continue
+		}
+
+		add(last, loc[0], string(raw[last:loc[0]]))
+		add(loc[0], loc[1], decoded)
+		last = loc[1]
+	}
+
+	add(last, len(raw), string(raw[last:]))
+}
+
+// Blocks returns the top level blocks of the document.
+func (d *Document) Blocks() []ast.Node {
+
+	blocks := []ast.Node{}
+
+	for n := d.Root.FirstChild(); n != nil; n = n.NextSibling() {
+		blocks = append(blocks, n)
+	}
+
+	return blocks
+}
+
+// Position returns the line and column of the byte offset in Source, both
+// starting at 1. The column counts characters rather than bytes. Offsets
+// outside of Source are moved to its start or end.
+func (d *Document) Position(offset int) (int, int) {
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(d.Source) {
+		offset = len(d.Source)
+	}
+
+	before := d.Source[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	start := bytes.LastIndexByte(before, '\n') + 1
+
+	return line, utf8.RuneCount(before[start:]) + 1
+}
+
+// parseHTML pulls the links, images and text out of a fragment of raw HTML.
+func (d *Document) parseHTML(raw []byte, start int) {
+
+	z := xhtml.NewTokenizer(bytes.NewReader(raw))
+	pos := start
+
+	for {
+		tt := z.Next()
+
+		// offset of the current token in the source
+		offset := pos
+		pos += len(z.Raw())
+
+		switch tt {
+		case xhtml.ErrorToken:
+			return
+
+		case xhtml.TextToken:
+			if value := string(z.Text()); strings.TrimSpace(value) != "" {
+				d.Texts = append(d.Texts, Text{
+					Value:  value,
+					Offset: offset,
+					End:    pos,
+					Run:    d.run,
+				})
+			}
+
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			if !inlineTags[string(name)] {
+				d.run++
+			}
+
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			tok := z.Token()
+
+			if !inlineTags[tok.Data] {
+				d.run++
+			}
+
+			d.Tags = append(d.Tags, Tag{
+				Name:   tok.Data,
+				Offset: offset,
+			})
+
+			switch tok.Data {
+			case "img":
+				if src := attr(tok, "src"); src != "" {
+					d.Links = append(d.Links, Link{
+						URL:    src,
+						Text:   attr(tok, "alt"),
+						Image:  true,
+						Offset: offset,
+					})
+				}
+			case "a":
+				if href := attr(tok, "href"); href != "" {
+					d.Links = append(d.Links, Link{
+						URL:    href,
+						Offset: offset,
+					})
+				}
+			}
+		}
+	}
+}
+
+func attr(tok xhtml.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+// offset finds the byte offset of an inline node by looking for the first
+// text below it, falling back to the start of the block it belongs to.
+func offset(n ast.Node, source []byte) int {
+
+	var found *ast.Text
+
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			found = t
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	if found != nil {
+		return found.Segment.Start
+	}
+
+	for p := n; p != nil; p = p.Parent() {
+		if p.Type() == ast.TypeBlock && p.Lines().Len() > 0 {
+			return p.Lines().At(0).Start
+		}
+	}
+
+	return 0
+}
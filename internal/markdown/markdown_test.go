@@ -0,0 +1,138 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinks(t *testing.T) {
+
+	doc := Parse(`# Heading with [a link](https://example.com/heading)
+
+A [reference link][ref] and an autolink <https://example.com/auto>.
+
+<img src="https://example.com/raw.png" alt="raw">
+
+![image](https://example.com/image.png)
+
+[ref]: https://example.com/ref
+`)
+
+	urls := map[string]bool{}
+	for _, link := range doc.Links {
+		urls[link.URL] = link.Image
+	}
+
+	assert.Len(t, doc.Links, 5)
+	assert.Equal(t, false, urls["https://example.com/heading"])
+	assert.Equal(t, false, urls["https://example.com/ref"])
+	assert.Equal(t, false, urls["https://example.com/auto"])
+	assert.Equal(t, true, urls["https://example.com/raw.png"])
+	assert.Equal(t, true, urls["https://example.com/image.png"])
+}
+
+func TestParseHeadings(t *testing.T) {
+
+	doc := Parse("Setext Heading\n===\n\n## Second\n\nSome text")
+
+	assert.Len(t, doc.Headings, 2)
+	assert.Equal(t, 1, doc.Headings[0].Level)
+	assert.Equal(t, "Setext Heading", doc.Headings[0].Text)
+	assert.Equal(t, 2, doc.Headings[1].Level)
+	assert.Len(t, doc.Blocks(), 3)
+}
+
+func TestParseTexts(t *testing.T) {
+
+	body := "# Title\n\nSome *emphasis* text\n\n<p>html text</p>\n"
+	doc := Parse(body)
+
+	values := []string{}
+	for _, txt := range doc.Texts {
+		values = append(values, txt.Value)
+		if txt.Value == "emphasis" {
+			assert.Equal(t, "emphasis", body[txt.Offset:txt.Offset+len(txt.Value)])
+		}
+	}
+
+	assert.Contains(t, values, "Title")
+	assert.Contains(t, values, "emphasis")
+	assert.Contains(t, values, "html text")
+}
+
+func TestParseTextRuns(t *testing.T) {
+
+	doc := Parse("# Title\n\nSome *emphasis*\ntext [link](https://example.com) after\n\nNext\n")
+
+	runs := map[string]int{}
+	for _, txt := range doc.Texts {
+		runs[txt.Value] = txt.Run
+	}
+
+	assert.Equal(t, runs["Some "], runs["emphasis"])
+	assert.Equal(t, runs["Some "], runs["text "])
+	assert.NotEqual(t, runs["Title"], runs["Some "])
+	assert.NotEqual(t, runs["text "], runs["link"])
+	assert.NotEqual(t, runs["link"], runs[" after"])
+	assert.NotEqual(t, runs[" after"], runs["Next"])
+}
+
+func TestParseTextEntities(t *testing.T) {
+
+	body := "A caf&eacute; &amp; `&amp;` &bogus;"
+	doc := Parse(body)
+
+	values := []string{}
+	for _, txt := range doc.Texts {
+		values = append(values, txt.Value)
+
+		// decoded entities still point at their source
+		if txt.Value == "é" {
+			assert.Equal(t, "&eacute;", body[txt.Offset:txt.End])
+		}
+	}
+
+	assert.Equal(t, []string{"A caf", "é", " ", "&", " ", "&amp;", " &bogus;"}, values)
+}
+
+func TestParseTags(t *testing.T) {
+
+	body := "# Title\n\nSome <B>bold</B> text\n\n<SCRIPT>alert(1)</SCRIPT>\n"
+	doc := Parse(body)
+
+	names := []string{}
+	for _, tag := range doc.Tags {
+		names = append(names, tag.Name)
+	}
+
+	assert.Equal(t, []string{"b", "script"}, names)
+	assert.Equal(t, "<SCRIPT>", body[doc.Tags[1].Offset:doc.Tags[1].Offset+8])
+}
+
+func TestParseEmpty(t *testing.T) {
+
+	doc := Parse("")
+
+	assert.Len(t, doc.Blocks(), 0)
+	assert.Len(t, doc.Links, 0)
+}
+
+func TestPosition(t *testing.T) {
+
+	doc := Parse("# Title\n\ncafé au lait")
+
+	cases := map[int][2]int{
+		0:   {1, 1},
+		2:   {1, 3},
+		9:   {3, 1},
+		14:  {3, 5},
+		-1:  {1, 1},
+		100: {3, 13},
+	}
+
+	for offset, want := range cases {
+		line, column := doc.Position(offset)
+		assert.Equal(t, want, [2]int{line, column}, offset)
+	}
+}
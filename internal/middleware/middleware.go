@@ -60,3 +60,20 @@ func RequestID() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// ReviewerKey is where the identity of the moderator making a request is
+// kept on the gin context.
+const ReviewerKey = "reviewer"
+
+// Reviewer takes the moderator's identity from a header set by the auth
+// proxy in front of the service. Nothing is set when header is empty.
+func Reviewer(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header != "" {
+			if reviewer := c.GetHeader(header); reviewer != "" {
+				c.Set(ReviewerKey, reviewer)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,578 @@
+package bannedwords
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/ahocorasick"
+	"github.com/kramllih/filterService/internal/logger"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+)
+
+const Name string = "bannedwords"
+
+// Codes of the findings the rule makes.
+const (
+	CodeBannedWord  string = "BANNED_WORD"
+	CodeUnavailable string = "BANNED_LIST_UNAVAILABLE"
+)
+
+// Policies for when there is no banned word list to check against.
+const (
+	FailClosed string = "closed"
+	FailOpen   string = "open"
+)
+
+// Match modes for banned terms.
+const (
+	ModeExact     string = "exact"
+	ModeSubstring string = "substring"
+	ModePrefix    string = "prefix"
+	ModeStem      string = "stem"
+	ModePhrase    string = "phrase"
+)
+
+type termCfg struct {
+	Word     string
+	Mode     string
+	Severity string
+}
+
+type bannedCfg struct {
+	Unavailable     string
+	CollapseRepeats bool
+	Mode            string
+	Severity        string
+	Terms           []termCfg
+	Allow           []string
+}
+
+// term is a banned entry after normalisation. Phrases have one entry in
+// words per word of the phrase.
+type term struct {
+	words    []string
+	mode     string
+	severity string
+}
+
+// termOptions are the mode and severity configured for a word, empty
+// values fall back to the defaults of the rule.
+type termOptions struct {
+	mode     string
+	severity string
+}
+
+type bannedWords struct {
+	words       rules.WordSource
+	norm        normalizer
+	unavailable string
+	log         *logger.Logger
+
+	mode     string
+	severity string
+	terms    map[string]termOptions
+	allow    map[string]bool
+
+	mu      sync.Mutex
+	version uint64
+	matcher *compiled
+}
+
+func init() {
+	rules.RegisterType(Name, New)
+}
+
+func New(cfg *config.RawConfig, deps rules.Deps) (rules.Rule, error) {
+
+	if deps.Words == nil {
+		return nil, errors.New("no banned word source configured")
+	}
+
+	bannedConfig := bannedCfg{
+		Unavailable: FailClosed,
+		Mode:        ModeExact,
+		Severity:    rules.SeverityReject,
+	}
+
+	if err := cfg.UnpackRaw(&bannedConfig); err != nil {
+		return nil, err
+	}
+
+	if bannedConfig.Unavailable != FailClosed && bannedConfig.Unavailable != FailOpen {
+		return nil, fmt.Errorf("unknown unavailable policy %q, must be %s or %s", bannedConfig.Unavailable, FailClosed, FailOpen)
+	}
+
+	b := &bannedWords{
+		words:       deps.Words,
+		unavailable: bannedConfig.Unavailable,
+		log:         logger.NewLogger(Name),
+		norm: normalizer{
+			collapseRepeats: bannedConfig.CollapseRepeats,
+		},
+		terms: map[string]termOptions{},
+		allow: map[string]bool{},
+	}
+
+	mode, err := parseMode(bannedConfig.Mode)
+	if err != nil {
+		return nil, err
+	}
+	b.mode = mode
+
+	severity, err := parseSeverity(bannedConfig.Severity)
+	if err != nil {
+		return nil, err
+	}
+	b.severity = severity
+
+	for _, t := range bannedConfig.Terms {
+		mode, err := parseMode(t.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("term %q: %w", t.Word, err)
+		}
+
+		severity, err := parseSeverity(t.Severity)
+		if err != nil {
+			return nil, fmt.Errorf("term %q: %w", t.Word, err)
+		}
+
+		b.terms[t.Word] = termOptions{
+			mode:     mode,
+			severity: severity,
+		}
+	}
+
+	for _, word := range bannedConfig.Allow {
+		if normal := b.norm.normalize(word); normal != "" {
+			b.allow[normal] = true
+		}
+	}
+
+	return b, nil
+}
+
+func parseMode(mode string) (string, error) {
+
+	switch strings.ToLower(mode) {
+	case "":
+		return "", nil
+	case ModeExact:
+		return ModeExact, nil
+	case ModeSubstring:
+		return ModeSubstring, nil
+	case ModePrefix, ModeStem:
+		return ModePrefix, nil
+	case ModePhrase:
+		return ModePhrase, nil
+	}
+
+	return "", fmt.Errorf("unknown match mode %q", mode)
+}
+
+func parseSeverity(severity string) (string, error) {
+
+	switch strings.ToLower(severity) {
+	case "":
+		return "", nil
+	case rules.SeverityReject:
+		return rules.SeverityReject, nil
+	case rules.SeverityReview:
+		return rules.SeverityReview, nil
+	case rules.SeverityMask:
+		return rules.SeverityMask, nil
+	}
+
+	return "", fmt.Errorf("unknown severity %q", severity)
+}
+
+func (b *bannedWords) Name() string {
+	return Name
+}
+
+func (b *bannedWords) Evaluate(in *rules.Input) ([]rules.Finding, error) {
+
+	if in.Doc == nil {
+		return nil, nil
+	}
+
+	list := b.words.BannedWords
+	if in.DryRun {
+		list = b.words.ReadOnlyWords
+	}
+
+	words, version, err := list()
+	if err != nil {
+		return b.handleUnavailable(in, err)
+	}
+
+	tokens := b.tokenize(in.Doc)
+
+	hits := b.match(tokens, b.compiled(words, version))
+
+	findings := []rules.Finding{}
+	reviewed := map[string]bool{}
+
+	for _, h := range hits {
+		matched := tokens[h.first : h.last+1]
+		word := joinOriginal(matched)
+
+		start := matched[0].Offset
+		last := matched[len(matched)-1]
+		length := last.End - start
+
+		switch h.severity {
+		case rules.SeverityReject:
+			f := rules.Finding{
+				Rule:     Name,
+				Code:     CodeBannedWord,
+				Severity: rules.SeverityReject,
+				Reason:   fmt.Sprintf("message body contains banned word [%s]", word),
+				Target:   word,
+			}
+			rules.Locate(&f, in.Doc, start, length)
+
+			findings = append(findings, f)
+
+		case rules.SeverityReview:
+			// one approval is enough for every use of the word
+			key := joinNormal(matched)
+			if reviewed[key] {
+				continue
+			}
+			reviewed[key] = true
+
+			f := rules.Finding{
+				Rule:     Name,
+				Code:     CodeBannedWord,
+				Severity: rules.SeverityReview,
+				Reason:   fmt.Sprintf("word [%s] requires approval", word),
+				Target:   word,
+			}
+			rules.Locate(&f, in.Doc, start, length)
+
+			findings = append(findings, f)
+
+		case rules.SeverityMask:
+			// each word is masked on its own so nothing between the
+			// words of a phrase is lost. The word is left out of the
+			// finding, which is stored and returned with the message,
+			// only its position is kept.
+			for _, tok := range matched {
+				f := rules.Finding{
+					Rule:     Name,
+					Code:     CodeBannedWord,
+					Severity: rules.SeverityMask,
+					Reason:   "a banned word has been masked",
+				}
+				rules.Locate(&f, in.Doc, tok.Offset, tok.End-tok.Offset)
+
+				findings = append(findings, f)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// tokenize splits the texts of the document into tokens. Texts in the same
+// run with only markup between them are joined first, so a word split up by
+// emphasis, an empty tag or an entity is still one word. The Offset and End
+// of every token are offsets in the source.
+func (b *bannedWords) tokenize(doc *markdown.Document) []token {
+
+	tokens := []token{}
+	texts := doc.Texts
+	run := 0
+
+	for i := 0; i < len(texts); {
+		var joined strings.Builder
+		// the source offsets of the start and end of each joined byte
+		starts, ends := []int{}, []int{}
+
+		j := i
+		for ; j < len(texts); j++ {
+			text := texts[j]
+			if j > i && !adjacent(doc.Source, texts[j-1], text) {
+				break
+			}
+
+			// a decoded entity maps onto all of its source
+			exact := text.End-text.Offset == len(text.Value)
+			for k := 0; k < len(text.Value); k++ {
+				if exact {
+					starts = append(starts, text.Offset+k)
+					ends = append(ends, text.Offset+k+1)
+				} else {
+					starts = append(starts, text.Offset)
+					ends = append(ends, text.End)
+				}
+			}
+
+			joined.WriteString(text.Value)
+		}
+
+		for _, tok := range b.norm.tokens(joined.String()) {
+			last := tok.Offset + len(tok.Original) - 1
+			tok.Offset, tok.End = starts[tok.Offset], ends[last]
+
+			tok.Break = len(tokens) > 0 && texts[i].Run != run
+			run = texts[i].Run
+
+			tokens = append(tokens, tok)
+		}
+
+		i = j
+	}
+
+	return tokens
+}
+
+// adjacent reports whether next carries straight on from prev, in the same
+// run with no white space between them in the source.
+func adjacent(source []byte, prev, next markdown.Text) bool {
+
+	if prev.Run != next.Run || prev.End > next.Offset || next.Offset > len(source) {
+		return false
+	}
+
+	return len(bytes.TrimFunc(source[prev.End:next.Offset], func(r rune) bool {
+		return !unicode.IsSpace(r)
+	})) == 0
+}
+
+// handleUnavailable applies the unavailable policy when there is no list
+// to check the message against. Failing closed sends the message for
+// review, failing open lets it through unchecked.
+func (b *bannedWords) handleUnavailable(in *rules.Input, err error) ([]rules.Finding, error) {
+
+	messageID := ""
+	if in.Message != nil {
+		messageID = in.Message.ID
+	}
+
+	if b.unavailable == FailOpen {
+		b.log.WithField("messageId", messageID).Warnf("banned words not checked: %s", err)
+		return nil, nil
+	}
+
+	b.log.WithField("messageId", messageID).Warnf("message requires review as banned words could not be checked: %s", err)
+
+	return []rules.Finding{
+		{
+			Rule:     Name,
+			Code:     CodeUnavailable,
+			Severity: rules.SeverityReview,
+			Reason:   "banned words could not be checked, message requires review",
+		},
+	}, nil
+}
+
+// compiled is the banned list built into a single matcher. Every pattern
+// can be shared by more than one term when they only differ by mode.
+type compiled struct {
+	matcher *ahocorasick.Matcher
+	terms   [][]term
+}
+
+// compiled returns the matcher for words, only rebuilding it when the
+// version of the list has changed since the last call.
+func (b *bannedWords) compiled(words []string, version uint64) *compiled {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.matcher != nil && b.version == version {
+		return b.matcher
+	}
+
+	b.matcher = b.compile(words)
+	b.version = version
+
+	return b.matcher
+}
+
+// compile normalises the banned words along with the configured terms,
+// works out the match mode of each one and builds them into a matcher.
+func (b *bannedWords) compile(words []string) *compiled {
+
+	options := map[string]termOptions{}
+	for _, word := range words {
+		options[word] = b.terms[word]
+	}
+	for word, opts := range b.terms {
+		options[word] = opts
+	}
+
+	c := &compiled{}
+	patterns := []string{}
+	index := map[string]int{}
+
+	for word, opts := range options {
+		t := term{
+			mode:     opts.mode,
+			severity: opts.severity,
+		}
+
+		if t.severity == "" {
+			t.severity = b.severity
+		}
+
+		for _, tok := range b.norm.tokens(word) {
+			t.words = append(t.words, tok.Normal)
+		}
+
+		if len(t.words) == 0 {
+			continue
+		}
+
+		switch {
+		case len(t.words) > 1:
+			t.mode = ModePhrase
+		case t.mode == "":
+			t.mode = b.mode
+		}
+
+		// a phrase of one word is just a word
+		if t.mode == ModePhrase && len(t.words) == 1 {
+			t.mode = ModeExact
+		}
+
+		pattern := strings.Join(t.words, " ")
+
+		i, ok := index[pattern]
+		if !ok {
+			i = len(patterns)
+			index[pattern] = i
+			patterns = append(patterns, pattern)
+			c.terms = append(c.terms, nil)
+		}
+
+		c.terms[i] = append(c.terms[i], t)
+	}
+
+	c.matcher = ahocorasick.New(patterns)
+
+	return c
+}
+
+// span is a run of tokens, first to last inclusive, that matched a term.
+type span struct {
+	first int
+	last  int
+}
+
+// hit is a span that matched, with the severity of the term it matched.
+type hit struct {
+	span
+	severity string
+}
+
+// match returns the runs of tokens that match any of the terms, in the
+// order they appear. The normalised tokens are joined with single spaces,
+// or a new line at a break which no pattern can contain, and scanned once.
+// Each hit is then checked against the mode of the terms it came from.
+func (b *bannedWords) match(tokens []token, c *compiled) []hit {
+
+	var text strings.Builder
+	starts := make([]int, len(tokens))
+	ends := make([]int, len(tokens))
+	owner := []int{}
+
+	for i, tok := range tokens {
+		if i > 0 {
+			if tok.Break {
+				text.WriteByte('\n')
+			} else {
+				text.WriteByte(' ')
+			}
+			owner = append(owner, -1)
+		}
+
+		starts[i] = text.Len()
+		text.WriteString(tok.Normal)
+		ends[i] = text.Len()
+
+		for j := 0; j < len(tok.Normal); j++ {
+			owner = append(owner, i)
+		}
+	}
+
+	found := map[span]int{}
+	hits := []hit{}
+
+	for _, m := range c.matcher.Find(text.String()) {
+		first, last := owner[m.Start], owner[m.End-1]
+		if first < 0 || last < 0 {
+			continue
+		}
+
+		atStart := m.Start == starts[first]
+		atEnd := m.End == ends[last]
+
+		for _, t := range c.terms[m.Pattern] {
+			ok := false
+
+			switch t.mode {
+			case ModePhrase:
+				ok = atStart && atEnd
+			case ModeSubstring:
+				ok = first == last
+			case ModePrefix:
+				ok = first == last && atStart
+			default:
+				ok = first == last && atStart && atEnd
+			}
+
+			if !ok || (t.mode != ModePhrase && b.allow[tokens[first].Normal]) {
+				continue
+			}
+
+			s := span{first, last}
+
+			i, seen := found[s]
+			if !seen {
+				found[s] = len(hits)
+				hits = append(hits, hit{span: s, severity: t.severity})
+				continue
+			}
+
+			// matched by more than one term, the most severe wins
+			if rules.MoreSevere(t.severity, hits[i].severity) {
+				hits[i].severity = t.severity
+			}
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].first < hits[j].first
+	})
+
+	return hits
+}
+
+func joinOriginal(tokens []token) string {
+
+	words := []string{}
+	for _, tok := range tokens {
+		words = append(words, tok.Original)
+	}
+
+	return strings.Join(words, " ")
+}
+
+func joinNormal(tokens []token) string {
+
+	words := []string{}
+	for _, tok := range tokens {
+		words = append(words, tok.Normal)
+	}
+
+	return strings.Join(words, " ")
+}
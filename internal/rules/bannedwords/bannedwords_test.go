@@ -0,0 +1,504 @@
+package bannedwords
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockWords []string
+
+func (m mockWords) BannedWords() ([]string, uint64, error) {
+	return m, 1, nil
+}
+
+func (m mockWords) ReadOnlyWords() ([]string, uint64, error) {
+	return m.BannedWords()
+}
+
+func mockRule(t *testing.T, cfg config.RawConfig, words ...string) rules.Rule {
+
+	rule, err := New(&cfg, rules.Deps{Words: mockWords(words)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rule
+}
+
+func TestBannedWordVariants(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{}, "adult")
+
+	bodies := map[string]string{
+		"punctuation": "# Title\n\nThis is adult, content",
+		"exclaim":     "# Title\n\nSo adult!",
+		"zero width":  "# Title\n\nThis is ad\u200bult content",
+		"cyrillic":    "# Title\n\nThis is \u0430dult content",
+		"upper":       "# Title\n\nThis is ADULT content",
+		"heading":     "# Adult title\n\nSome text",
+	}
+
+	for name, body := range bodies {
+		findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, findings, 1, name)
+	}
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\nThis is \u0430dult content")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "message body contains banned word [\u0430dult]", findings[0].Reason)
+	assert.Equal(t, CodeBannedWord, findings[0].Code)
+}
+
+func TestBannedWordCollapse(t *testing.T) {
+
+	doc := markdown.Parse("# Title\n\nThis is aaaduuult content")
+
+	findings, err := mockRule(t, config.RawConfig{}, "adult").Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, findings, 0)
+
+	findings, err = mockRule(t, config.RawConfig{"collapseRepeats": true}, "adult").Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, findings, 1)
+}
+
+func TestBannedWordModes(t *testing.T) {
+
+	cfg := config.RawConfig{
+		"terms": []interface{}{
+			map[string]interface{}{"word": "ass", "mode": "substring"},
+			map[string]interface{}{"word": "hell", "mode": "exact"},
+			map[string]interface{}{"word": "damn", "mode": "stem"},
+			map[string]interface{}{"word": "go away now", "mode": "phrase"},
+		},
+		"allow": []interface{}{"Classic"},
+	}
+
+	rule := mockRule(t, cfg, "adult", "bad idea")
+
+	cases := map[string]int{
+		"I read a classic":           0,
+		"a classic passage":          1,
+		"hello there":                0,
+		"what the hell.":             1,
+		"damned if you do":           1,
+		"please go away now!":        1,
+		"go away and come back":      0,
+		"a very Bad   Idea":          1,
+		"adultery is not adult":      1,
+		"I think it is a bad choice": 0,
+	}
+
+	for body, want := range cases {
+		findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\n" + body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, findings, want, body)
+	}
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\nplease Go Away now")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "message body contains banned word [Go Away now]", findings[0].Reason)
+	assert.Equal(t, 3, findings[0].Line)
+	assert.Equal(t, 8, findings[0].Column)
+	assert.Equal(t, 3, findings[0].EndLine)
+	assert.Equal(t, 19, findings[0].EndColumn)
+}
+
+func TestBannedWordSplitByMarkup(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{}, "adult")
+
+	// the source each finding should cover, empty for no finding
+	cases := map[string]string{
+		"adult":                  "adult",
+		"ad*ult*":                "ad*ult",
+		"**ad**ult":              "ad**ult",
+		"adu<b></b>lt":           "adu<b></b>lt",
+		"ad&#117;lt":             "ad&#117;lt",
+		"ad&#x75;lt":             "ad&#x75;lt",
+		"&amp;adult":             "adult",
+		"ad *ult*":               "",
+		"ad&nbsp;ult":            "",
+		"`ad&#117;lt`":           "",
+		"ad&notanentity;ult":     "",
+		"[ad](https://x.org)ult": "",
+	}
+
+	for body, covered := range cases {
+		source := "# Title\n\n" + body
+
+		findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse(source)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if covered == "" {
+			assert.Len(t, findings, 0, body)
+			continue
+		}
+
+		if assert.Len(t, findings, 1, body) {
+			f := findings[0]
+			assert.Equal(t, covered, source[f.Offset:f.Offset+f.Length], body)
+			assert.Equal(t, "adult", f.Target, body)
+		}
+	}
+}
+
+func TestBannedWordPhraseBoundaries(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{}, "bad idea")
+
+	cases := map[string]int{
+		"# Title\n\na bad idea":                  1,
+		"# Title\n\na bad\nidea":                 1,
+		"# Title\n\na *bad* idea":                1,
+		"# Title\n\na <b>bad</b> idea":           1,
+		"# Title\n\na bad\n\nidea":               0,
+		"# A bad\n\nidea":                        0,
+		"# Title\n\n- a bad\n- idea":             0,
+		"# Title\n\na bad [idea](https://x.org)": 0,
+		"# Title\n\n[a bad](https://x.org) idea": 0,
+		"# Title\n\n<p>a bad</p><p>idea</p>":     0,
+		"# Title\n\n[a bad idea](https://x.org)": 1,
+		"# Title\n\na bad\n\n\u200b\n\nidea":     0,
+	}
+
+	for body, want := range cases {
+		findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, findings, want, body)
+	}
+}
+
+func TestBannedWordPositions(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{}, "adult")
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\nadult\n\nthe café is adult")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, findings, 2) {
+		assert.Equal(t, 3, findings[0].Line)
+		assert.Equal(t, 1, findings[0].Column)
+		assert.Equal(t, 5, findings[0].Length)
+
+		// columns count characters, not bytes
+		assert.Equal(t, 5, findings[1].Line)
+		assert.Equal(t, 13, findings[1].Column)
+		assert.Equal(t, 18, findings[1].EndColumn)
+	}
+}
+
+func TestBannedWordDefaultMode(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{"mode": "substring"}, "adult")
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\nadultery")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, findings, 1)
+
+	_, err = New(&config.RawConfig{"mode": "fuzzy"}, rules.Deps{Words: mockWords{}})
+	assert.Error(t, err)
+}
+
+// naiveMatch is the nested loop matcher the automaton replaced, it is kept
+// to benchmark against.
+func naiveMatch(b *bannedWords, tokens []token, terms [][]term) []string {
+
+	var matchedWords []string
+
+	for i, tok := range tokens {
+		for _, ts := range terms {
+			for _, t := range ts {
+				if t.mode == ModePhrase {
+					ok := len(tokens[i:]) >= len(t.words)
+					for j := 0; ok && j < len(t.words); j++ {
+						ok = tokens[i+j].Normal == t.words[j]
+					}
+					if ok {
+						matchedWords = append(matchedWords, joinOriginal(tokens[i:i+len(t.words)]))
+					}
+					continue
+				}
+
+				if b.allow[tok.Normal] {
+					continue
+				}
+
+				ok := false
+				switch t.mode {
+				case ModeSubstring:
+					ok = strings.Contains(tok.Normal, t.words[0])
+				case ModePrefix:
+					ok = strings.HasPrefix(tok.Normal, t.words[0])
+				default:
+					ok = tok.Normal == t.words[0]
+				}
+
+				if ok {
+					matchedWords = append(matchedWords, tok.Original)
+				}
+			}
+		}
+	}
+
+	return matchedWords
+}
+
+func benchWords(n int) []string {
+
+	r := rand.New(rand.NewSource(1))
+	letters := "abcdefghijklmnopqrstuvwxyz"
+
+	words := make([]string, n)
+	for i := range words {
+		word := make([]byte, 5+r.Intn(6))
+		for j := range word {
+			word[j] = letters[r.Intn(len(letters))]
+		}
+		words[i] = string(word)
+	}
+
+	return words
+}
+
+func benchMessage() string {
+	return "# Benchmark\n\n" + strings.Repeat("The quick brown fox jumps over the lazy dog, and then it thinks about adult things for a while. ", 20)
+}
+
+func TestAutomatonMatchesNaive(t *testing.T) {
+
+	words := append(benchWords(500), "adult", "lazy dog", "thin")
+
+	rule := mockRule(t, config.RawConfig{
+		"terms": []interface{}{
+			map[string]interface{}{"word": "thin", "mode": "prefix"},
+			map[string]interface{}{"word": "whi", "mode": "substring"},
+		},
+	}, words...).(*bannedWords)
+
+	tokens := []token{}
+	for _, text := range markdown.Parse(benchMessage()).Texts {
+		tokens = append(tokens, rule.norm.tokens(text.Value)...)
+	}
+
+	c := rule.compile(words)
+
+	matched := []string{}
+	for _, h := range rule.match(tokens, c) {
+		matched = append(matched, joinOriginal(tokens[h.first:h.last+1]))
+	}
+
+	assert.Equal(t, naiveMatch(rule, tokens, c.terms), matched)
+}
+
+func benchmarkMatch(b *testing.B, n int, automaton bool) {
+
+	words := benchWords(n)
+
+	rule, err := New(&config.RawConfig{}, rules.Deps{Words: mockWords(words)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	banned := rule.(*bannedWords)
+
+	tokens := []token{}
+	for _, text := range markdown.Parse(benchMessage()).Texts {
+		tokens = append(tokens, banned.norm.tokens(text.Value)...)
+	}
+
+	c := banned.compile(words)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if automaton {
+			banned.match(tokens, c)
+		} else {
+			naiveMatch(banned, tokens, c.terms)
+		}
+	}
+}
+
+func BenchmarkNaive1k(b *testing.B)      { benchmarkMatch(b, 1000, false) }
+func BenchmarkNaive20k(b *testing.B)     { benchmarkMatch(b, 20000, false) }
+func BenchmarkAutomaton1k(b *testing.B)  { benchmarkMatch(b, 1000, true) }
+func BenchmarkAutomaton20k(b *testing.B) { benchmarkMatch(b, 20000, true) }
+
+// BenchmarkEvaluate20k covers a whole evaluation against a cached matcher,
+// including checking the list has not changed.
+func BenchmarkEvaluate20k(b *testing.B) {
+
+	rule, err := New(&config.RawConfig{}, rules.Deps{Words: mockWords(benchWords(20000))})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	in := &rules.Input{Doc: markdown.Parse(benchMessage())}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rule.Evaluate(in); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// versionedWords is a list that only counts as changed when its version
+// goes up.
+type versionedWords struct {
+	words   []string
+	version uint64
+}
+
+func (v *versionedWords) BannedWords() ([]string, uint64, error) {
+	return v.words, v.version, nil
+}
+
+func (v *versionedWords) ReadOnlyWords() ([]string, uint64, error) {
+	return v.BannedWords()
+}
+
+func TestBannedWordVersion(t *testing.T) {
+
+	source := &versionedWords{words: []string{"adult"}, version: 1}
+
+	rule, err := New(&config.RawConfig{}, rules.Deps{Words: source})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evaluate := func(body string) int {
+		findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(findings)
+	}
+
+	assert.Equal(t, 1, evaluate("# Title\n\nadult"))
+
+	// the matcher is kept until the version changes
+	source.words = []string{"jaw"}
+	assert.Equal(t, 1, evaluate("# Title\n\nadult"))
+	assert.Equal(t, 0, evaluate("# Title\n\njaw"))
+
+	source.version = 2
+	assert.Equal(t, 0, evaluate("# Title\n\nadult"))
+	assert.Equal(t, 1, evaluate("# Title\n\njaw"))
+}
+
+type failingWords struct{}
+
+func (f failingWords) BannedWords() ([]string, uint64, error) {
+	return nil, 0, errors.New("banned word list is unavailable")
+}
+
+func (f failingWords) ReadOnlyWords() ([]string, uint64, error) {
+	return f.BannedWords()
+}
+
+func TestBannedWordsUnavailable(t *testing.T) {
+
+	in := &rules.Input{Doc: markdown.Parse("# Title\n\nSome text")}
+
+	closed, err := New(&config.RawConfig{}, rules.Deps{Words: failingWords{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := closed.Evaluate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, findings, 1)
+	assert.Equal(t, rules.SeverityReview, findings[0].Severity)
+
+	open, err := New(&config.RawConfig{"unavailable": "open"}, rules.Deps{Words: failingWords{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err = open.Evaluate(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, findings, 0)
+
+	_, err = New(&config.RawConfig{"unavailable": "sometimes"}, rules.Deps{Words: failingWords{}})
+	assert.Error(t, err)
+}
+
+func TestBannedWordSeverity(t *testing.T) {
+
+	cfg := config.RawConfig{
+		"terms": []interface{}{
+			map[string]interface{}{"word": "darn", "severity": "mask"},
+			map[string]interface{}{"word": "heck", "severity": "review"},
+			map[string]interface{}{"word": "oh darn it", "severity": "reject"},
+		},
+	}
+
+	rule := mockRule(t, cfg, "adult")
+
+	body := "# Title\n\nWell darn, *darn* and heck and heck"
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: markdown.Parse(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	severities := map[string]int{}
+	for _, f := range findings {
+		severities[f.Severity]++
+
+		if f.Severity == rules.SeverityMask {
+			assert.Equal(t, "darn", body[f.Offset:f.Offset+f.Length])
+		}
+	}
+
+	assert.Equal(t, 2, severities[rules.SeverityMask])
+	assert.Equal(t, 1, severities[rules.SeverityReview])
+	assert.Equal(t, 0, severities[rules.SeverityReject])
+	assert.Equal(t, rules.SeverityReview, rules.MostSevere(findings))
+
+	// the phrase is more severe than the word inside it
+	findings, err = rule.Evaluate(&rules.Input{Doc: markdown.Parse("# Title\n\noh darn it")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, rules.SeverityReject, rules.MostSevere(findings))
+
+	_, err = New(&config.RawConfig{"severity": "shout"}, rules.Deps{Words: mockWords{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+package bannedwords
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// homoglyphs maps letters that look like latin letters onto the latin
+// letter, it is applied after case folding so only lower case is needed.
+var homoglyphs = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c',
+	'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l',
+	'ԛ': 'q', 'ԝ': 'w', 'ь': 'b',
+	// greek
+	'α': 'a', 'β': 'b', 'γ': 'y', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k',
+	'μ': 'u', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	'ω': 'w',
+	// latin look-alikes
+	'ı': 'i', 'ſ': 's', 'ɑ': 'a', 'ɡ': 'g', 'ɩ': 'i', 'ɪ': 'i', 'ʏ': 'y',
+}
+
+// token is a single word from a message. Original is the word as the
+// user wrote it, less any punctuation around it, Offset is the byte offset
+// of Original in the text it came from and End the offset just after it.
+// Break is set on the first token of a new run of text, a phrase can't
+// match across it.
+type token struct {
+	Original string
+	Normal   string
+	Offset   int
+	End      int
+	Break    bool
+}
+
+type normalizer struct {
+	collapseRepeats bool
+}
+
+// tokens splits text on white space and normalises every word.
+func (n normalizer) tokens(text string) []token {
+
+	tokens := []token{}
+	start := -1
+
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+
+		word := text[start:end]
+		trimmedLeft := strings.TrimLeftFunc(word, isEdge)
+		original := strings.TrimRightFunc(trimmedLeft, isEdge)
+
+		if normal := n.normalize(original); normal != "" {
+			offset := start + len(word) - len(trimmedLeft)
+			tokens = append(tokens, token{
+				Original: original,
+				Normal:   normal,
+				Offset:   offset,
+				End:      offset + len(original),
+			})
+		}
+
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			flush(i)
+			continue
+		}
+
+		if start < 0 {
+			start = i
+		}
+	}
+	flush(len(text))
+
+	return tokens
+}
+
+// normalize folds a word so that look-alike spellings compare equal. It
+// drops invisible characters, applies NFKC, folds case and accents and
+// maps homoglyphs onto latin letters.
+func (n normalizer) normalize(word string) string {
+
+	word = strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, word)
+
+	word = norm.NFKC.String(word)
+	word = strings.ToLower(word)
+
+	// strip accents by decomposing and dropping the combining marks
+	word = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		if g, ok := homoglyphs[r]; ok {
+			return g
+		}
+		return r
+	}, norm.NFD.String(word))
+
+	word = norm.NFC.String(word)
+
+	// compatibility forms can decompose to include spaces
+	word = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, word)
+	word = strings.TrimFunc(word, isEdge)
+
+	if n.collapseRepeats {
+		word = collapse(word)
+	}
+
+	return word
+}
+
+// collapse folds runs of the same letter down to a single letter.
+func collapse(word string) string {
+
+	var b strings.Builder
+	last := utf8.RuneError
+
+	for _, r := range word {
+		if r == last && unicode.IsLetter(r) {
+			continue
+		}
+		b.WriteRune(r)
+		last = r
+	}
+
+	return b.String()
+}
+
+// isEdge reports whether r can be trimmed from the start or end of a word.
+func isEdge(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r) || isInvisible(r)
+}
+
+// isInvisible reports whether r is a control or zero width character.
+func isInvisible(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
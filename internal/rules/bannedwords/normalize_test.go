@@ -0,0 +1,53 @@
+package bannedwords
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+
+	n := normalizer{}
+
+	cases := map[string]string{
+		"badword":            "badword",
+		"BadWord":            "badword",
+		"badword,":           "badword",
+		"(badword)!":         "badword",
+		"b\u200badword":      "badword",
+		"bad\u00adword":      "badword",
+		"bаdwоrd":            "badword", // cyrillic a and o
+		"ｂａｄｗｏｒｄ":            "badword", // full width
+		"bädwörd":            "badword",
+		"badword\u0007":      "badword",
+		"don't":              "don't",
+		"...":                "",
+		"\u200b\u200c\u200d": "",
+	}
+
+	for in, want := range cases {
+		assert.Equal(t, want, n.normalize(in), in)
+	}
+}
+
+func TestNormalizeCollapse(t *testing.T) {
+
+	n := normalizer{collapseRepeats: true}
+
+	assert.Equal(t, "badword", n.normalize("baaaddword"))
+	assert.Equal(t, "badword", n.normalize("badword"))
+}
+
+func TestTokens(t *testing.T) {
+
+	n := normalizer{}
+
+	text := "This is a (b\u200badword)! and  more"
+	tokens := n.tokens(text)
+
+	assert.Len(t, tokens, 6)
+	assert.Equal(t, "b\u200badword", tokens[3].Original)
+	assert.Equal(t, "badword", tokens[3].Normal)
+	assert.Equal(t, tokens[3].Original, text[tokens[3].Offset:tokens[3].Offset+len(tokens[3].Original)])
+}
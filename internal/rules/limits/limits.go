@@ -0,0 +1,193 @@
+package limits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/yuin/goldmark/ast"
+)
+
+const Name string = "limits"
+
+// Codes of the findings the rule makes.
+const (
+	CodeBodyTooLarge     string = "BODY_TOO_LARGE"
+	CodeTooManyLines     string = "TOO_MANY_LINES"
+	CodeLineTooLong      string = "LINE_TOO_LONG"
+	CodeTooManyLinks     string = "TOO_MANY_LINKS"
+	CodeTooManyImages    string = "TOO_MANY_IMAGES"
+	CodeTooManyHeadings  string = "TOO_MANY_HEADINGS"
+	CodeTooFewParagraphs string = "TOO_FEW_PARAGRAPHS"
+)
+
+// Config holds the limits, a maximum of 0 is no limit. Line length is
+// counted in characters, body size in bytes.
+type Config struct {
+	MaxBodyBytes  int
+	MaxLines      int
+	MaxLineLength int
+	MaxLinks      int
+	MaxImages     int
+	MaxHeadings   int
+	MinParagraphs int
+}
+
+type limitsRule struct {
+	cfg Config
+}
+
+func init() {
+	rules.RegisterType(Name, New)
+}
+
+func New(cfg *config.RawConfig, deps rules.Deps) (rules.Rule, error) {
+
+	limitsConfig := Config{
+		MaxBodyBytes:  64 * 1024,
+		MaxLines:      1000,
+		MaxLineLength: 4096,
+		MaxLinks:      50,
+		MaxImages:     20,
+		MaxHeadings:   50,
+	}
+
+	if err := cfg.UnpackRaw(&limitsConfig); err != nil {
+		return nil, err
+	}
+
+	for _, v := range []int{
+		limitsConfig.MaxBodyBytes,
+		limitsConfig.MaxLines,
+		limitsConfig.MaxLineLength,
+		limitsConfig.MaxLinks,
+		limitsConfig.MaxImages,
+		limitsConfig.MaxHeadings,
+		limitsConfig.MinParagraphs,
+	} {
+		if v < 0 {
+			return nil, errors.New("limits can't be negative")
+		}
+	}
+
+	return &limitsRule{
+		cfg: limitsConfig,
+	}, nil
+}
+
+func (l *limitsRule) Name() string {
+	return Name
+}
+
+// Evaluate returns the same findings as Check, so limits are still applied
+// to messages that were accepted before they changed.
+func (l *limitsRule) Evaluate(in *rules.Input) ([]rules.Finding, error) {
+	return l.Check(in), nil
+}
+
+// Precheck measures the size of the body, which doesn't need it parsed.
+func (l *limitsRule) Precheck(in *rules.Input) []rules.Finding {
+
+	if in.Doc == nil {
+		return nil
+	}
+
+	source := in.Doc.Source
+
+	if l.cfg.MaxBodyBytes > 0 && len(source) > l.cfg.MaxBodyBytes {
+		f := finding(CodeBodyTooLarge, fmt.Sprintf("body is %d bytes, the limit is %d", len(source), l.cfg.MaxBodyBytes))
+		rules.Locate(&f, in.Doc, l.cfg.MaxBodyBytes, len(source)-l.cfg.MaxBodyBytes)
+
+		return []rules.Finding{f}
+	}
+
+	return nil
+}
+
+// Check measures the message against every limit. A body over the size
+// limit isn't measured any further.
+func (l *limitsRule) Check(in *rules.Input) []rules.Finding {
+
+	if in.Doc == nil {
+		return nil
+	}
+
+	if findings := l.Precheck(in); len(findings) > 0 {
+		return findings
+	}
+
+	findings := []rules.Finding{}
+	source := in.Doc.Source
+
+	lines := bytes.Split(bytes.TrimSuffix(source, []byte("\n")), []byte("\n"))
+
+	if l.cfg.MaxLines > 0 && len(lines) > l.cfg.MaxLines {
+		f := finding(CodeTooManyLines, fmt.Sprintf("body has %d lines, the limit is %d", len(lines), l.cfg.MaxLines))
+		rules.Locate(&f, in.Doc, 0, len(source))
+
+		findings = append(findings, f)
+	}
+
+	if l.cfg.MaxLineLength > 0 {
+		offset := 0
+
+		for i, line := range lines {
+			line = bytes.TrimSuffix(line, []byte("\r"))
+
+			if n := utf8.RuneCount(line); n > l.cfg.MaxLineLength {
+				f := finding(CodeLineTooLong, fmt.Sprintf("line %d is %d characters, the limit is %d", i+1, n, l.cfg.MaxLineLength))
+				rules.Locate(&f, in.Doc, offset, len(line))
+
+				findings = append(findings, f)
+			}
+
+			offset += len(lines[i]) + 1
+		}
+	}
+
+	links, images := 0, 0
+	for _, link := range in.Doc.Links {
+		if link.Image {
+			images++
+		} else {
+			links++
+		}
+	}
+
+	if l.cfg.MaxLinks > 0 && links > l.cfg.MaxLinks {
+		findings = append(findings, finding(CodeTooManyLinks, fmt.Sprintf("body has %d links, the limit is %d", links, l.cfg.MaxLinks)))
+	}
+
+	if l.cfg.MaxImages > 0 && images > l.cfg.MaxImages {
+		findings = append(findings, finding(CodeTooManyImages, fmt.Sprintf("body has %d images, the limit is %d", images, l.cfg.MaxImages)))
+	}
+
+	if headings := len(in.Doc.Headings); l.cfg.MaxHeadings > 0 && headings > l.cfg.MaxHeadings {
+		findings = append(findings, finding(CodeTooManyHeadings, fmt.Sprintf("body has %d headings, the limit is %d", headings, l.cfg.MaxHeadings)))
+	}
+
+	paragraphs := 0
+	for _, block := range in.Doc.Blocks() {
+		if block.Kind() == ast.KindParagraph {
+			paragraphs++
+		}
+	}
+
+	if paragraphs < l.cfg.MinParagraphs {
+		findings = append(findings, finding(CodeTooFewParagraphs, fmt.Sprintf("body has %d paragraphs, at least %d are needed", paragraphs, l.cfg.MinParagraphs)))
+	}
+
+	return findings
+}
+
+func finding(code, reason string) rules.Finding {
+	return rules.Finding{
+		Rule:     Name,
+		Code:     code,
+		Severity: rules.SeverityInvalid,
+		Reason:   reason,
+	}
+}
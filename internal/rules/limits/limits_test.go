@@ -0,0 +1,120 @@
+package limits
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockRule(t *testing.T, cfg config.RawConfig) *limitsRule {
+
+	rule, err := New(&cfg, rules.Deps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rule.(*limitsRule)
+}
+
+func codes(findings []rules.Finding) []string {
+
+	found := []string{}
+	for _, f := range findings {
+		found = append(found, f.Code)
+	}
+
+	return found
+}
+
+func TestLimits(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{
+		"maxLines":      6,
+		"maxLineLength": 20,
+		"maxLinks":      1,
+		"maxImages":     1,
+		"maxHeadings":   1,
+		"minParagraphs": 1,
+	})
+
+	cases := map[string][]string{
+		"# Title\n\nSome text":                                 {},
+		"# Title\n\n- a list":                                  {CodeTooFewParagraphs},
+		"# Title\n\n1\n2\n3\n4\n5\n6":                          {CodeTooManyLines},
+		"# Title\n\n" + strings.Repeat("é", 21):                {CodeLineTooLong},
+		"# Title\n\n[a](#a) [b](#b)":                           {CodeTooManyLinks},
+		"# Title\n\n![a](a.png)\n![b](b.png)":                  {CodeTooManyImages},
+		"# Title\n\nSome text\n\n## Another":                   {CodeTooManyHeadings},
+		"# Title\n\n" + strings.Repeat("a", 21) + "\n\n## Two": {CodeLineTooLong, CodeTooManyHeadings},
+	}
+
+	for body, want := range cases {
+		findings := rule.Check(&rules.Input{Doc: markdown.Parse(body)})
+		assert.Equal(t, want, codes(findings), body)
+
+		for _, f := range findings {
+			assert.Equal(t, rules.SeverityInvalid, f.Severity)
+		}
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{"maxBodyBytes": 16, "maxLineLength": 4})
+
+	findings := rule.Check(&rules.Input{Doc: markdown.Parse("# Title\n\n" + strings.Repeat("a", 100))})
+
+	// nothing else is measured once the body is too large
+	if assert.Equal(t, []string{CodeBodyTooLarge}, codes(findings)) {
+		assert.Equal(t, 16, findings[0].Offset)
+		assert.Equal(t, 3, findings[0].Line)
+		assert.Equal(t, 8, findings[0].Column)
+	}
+}
+
+func TestPrecheck(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{"maxBodyBytes": 16, "maxLineLength": 4})
+
+	// only the size is measured, so the body doesn't need parsing
+	findings := rule.Precheck(&rules.Input{Doc: markdown.Unparsed("# Title\n\n" + strings.Repeat("a", 100))})
+	if assert.Equal(t, []string{CodeBodyTooLarge}, codes(findings)) {
+		assert.Equal(t, 3, findings[0].Line)
+		assert.Equal(t, 8, findings[0].Column)
+	}
+
+	findings = rule.Precheck(&rules.Input{Doc: markdown.Unparsed("# Title\n\nlong")})
+	assert.Len(t, findings, 0)
+}
+
+func TestLineTooLongPosition(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{"maxLineLength": 10})
+
+	findings := rule.Check(&rules.Input{Doc: markdown.Parse("# Title\r\n\r\nshort\r\nthis line is too long\r\n")})
+
+	if assert.Len(t, findings, 1) {
+		assert.Equal(t, "line 4 is 21 characters, the limit is 10", findings[0].Reason)
+		assert.Equal(t, 4, findings[0].Line)
+		assert.Equal(t, 1, findings[0].Column)
+		assert.Equal(t, 22, findings[0].EndColumn)
+	}
+}
+
+func TestNoLimit(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{"maxLines": 0, "maxLineLength": 0})
+
+	findings := rule.Check(&rules.Input{Doc: markdown.Parse("# Title\n\n" + strings.Repeat("a\n", 2000))})
+	assert.Len(t, findings, 0)
+}
+
+func TestNegativeLimit(t *testing.T) {
+
+	_, err := New(&config.RawConfig{"maxLinks": -1}, rules.Deps{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package links
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// domain is an entry of an allow, deny or review list. A host starting
+// with "*." matches any subdomain of the rest of the host, a path limits
+// the entry to that path and anything below it.
+type domain struct {
+	host     string
+	wildcard bool
+	path     string
+}
+
+type domainList []domain
+
+func parseDomains(entries []string) (domainList, error) {
+
+	list := domainList{}
+
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		// entries may be written as urls
+		if i := strings.Index(entry, "://"); i >= 0 {
+			entry = entry[i+3:]
+		}
+
+		d := domain{}
+
+		host := entry
+		if i := strings.Index(entry, "/"); i >= 0 {
+			host = entry[:i]
+			d.path = normalizePath(entry[i:])
+		}
+
+		if strings.HasPrefix(host, "*.") {
+			d.wildcard = true
+			host = host[2:]
+		}
+
+		d.host = strings.TrimSuffix(host, ".")
+
+		if d.host == "" || strings.Contains(d.host, "*") {
+			return nil, fmt.Errorf("invalid domain %q", entry)
+		}
+
+		list = append(list, d)
+	}
+
+	return list, nil
+}
+
+// matches reports whether any entry of the list covers u.
+func (l domainList) matches(u *url.URL) bool {
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	path := normalizePath(u.Path)
+
+	for _, d := range l {
+		if d.wildcard {
+			if !strings.HasSuffix(host, "."+d.host) {
+				continue
+			}
+		} else if host != d.host {
+			continue
+		}
+
+		if d.path == "" || path == d.path || strings.HasPrefix(path, d.path+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizePath puts the path of an entry or a link in the same form, so a
+// link can't get around an entry by its case, escapes or dot segments. The
+// root path is the same as no path.
+func normalizePath(p string) string {
+
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+
+	p = strings.ToLower(p)
+
+	if p == "" {
+		return ""
+	}
+
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+
+	return p
+}
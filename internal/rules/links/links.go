@@ -0,0 +1,210 @@
+package links
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/imageprobe"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+)
+
+const Name string = "links"
+
+// Codes of the findings the rule makes.
+const (
+	CodeExternalLink   string = "EXTERNAL_LINK"
+	CodeImageReview    string = "IMAGE_REVIEW"
+	CodeDeniedDomain   string = "DENIED_DOMAIN"
+	CodeReviewDomain   string = "REVIEW_DOMAIN"
+	CodePrivateAddress string = "PRIVATE_ADDRESS"
+)
+
+type linksCfg struct {
+	Allow  []string
+	Deny   []string
+	Review []string
+	// Deadline is how long the links of one message can take to probe.
+	Deadline time.Duration
+}
+
+type linkRule struct {
+	images   rules.ImageProber
+	deadline time.Duration
+
+	allow  domainList
+	deny   domainList
+	review domainList
+}
+
+func init() {
+	rules.RegisterType(Name, New)
+}
+
+func New(cfg *config.RawConfig, deps rules.Deps) (rules.Rule, error) {
+
+	if deps.Images == nil {
+		return nil, errors.New("no image prober configured")
+	}
+
+	linksConfig := linksCfg{
+		Deadline: 30 * time.Second,
+	}
+
+	if err := cfg.UnpackRaw(&linksConfig); err != nil {
+		return nil, err
+	}
+
+	if linksConfig.Deadline <= 0 {
+		return nil, errors.New("links deadline must be greater than 0")
+	}
+
+	l := &linkRule{
+		images:   deps.Images,
+		deadline: linksConfig.Deadline,
+	}
+
+	var err error
+
+	if l.allow, err = parseDomains(linksConfig.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+
+	if l.deny, err = parseDomains(linksConfig.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+
+	if l.review, err = parseDomains(linksConfig.Review); err != nil {
+		return nil, fmt.Errorf("review: %w", err)
+	}
+
+	return l, nil
+}
+
+func (l *linkRule) Name() string {
+	return Name
+}
+
+// Evaluate checks every link and image in the message. Links to denied
+// domains are rejected, links to review domains need approval and links to
+// allowed domains pass. Any other link to an external page is rejected and
+// every distinct external image is flagged for review. Links still being
+// probed when the deadline passes are treated as timed out.
+func (l *linkRule) Evaluate(in *rules.Input) ([]rules.Finding, error) {
+
+	if in.Doc == nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.deadline)
+	defer cancel()
+
+	findings := []rules.Finding{}
+	seen := map[string]bool{}
+
+	for _, link := range in.Doc.Links {
+
+		raw := strings.TrimSpace(link.URL)
+
+		u, ok := external(raw)
+		if !ok {
+			continue
+		}
+
+		if seen[raw] {
+			continue
+		}
+		seen[raw] = true
+
+		f := rules.Finding{
+			Rule:   Name,
+			Target: raw,
+		}
+
+		switch {
+		case l.deny.matches(u):
+			f.Code = CodeDeniedDomain
+			f.Severity = rules.SeverityReject
+			f.Reason = fmt.Sprintf("link [%s] is to a denied domain", raw)
+
+		case l.review.matches(u):
+			f.Code = CodeReviewDomain
+			f.Severity = rules.SeverityReview
+			f.Reason = fmt.Sprintf("link [%s] requires approval", raw)
+
+		case l.allow.matches(u):
+			continue
+
+		default:
+			probe := l.images.Probe(ctx, raw)
+
+			switch {
+			case probe.ErrClass == imageprobe.ClassBlocked:
+				f.Code = CodePrivateAddress
+				f.Severity = rules.SeverityReject
+				f.Reason = fmt.Sprintf("link [%s] is to a private address", raw)
+
+			case !probe.IsImage():
+				f.Code = CodeExternalLink
+				f.Severity = rules.SeverityReject
+				f.Reason = "message body contains external links"
+
+			default:
+				f.Code = CodeImageReview
+				f.Severity = rules.SeverityReview
+				f.Reason = fmt.Sprintf("image [%s] requires approval", raw)
+				f.Hash = probe.Hash
+			}
+		}
+
+		locate(&f, in.Doc, link)
+
+		findings = append(findings, f)
+	}
+
+	return findings, nil
+}
+
+// locate sets the position of the url of link on f. The url is looked for
+// from the start of the link, if it isn't there as written, such as when
+// it has entities in raw HTML, the start of the link is used.
+func locate(f *rules.Finding, doc *markdown.Document, link markdown.Link) {
+
+	start := link.Offset
+	if start < 0 || start > len(doc.Source) {
+		start = 0
+	}
+
+	if i := bytes.Index(doc.Source[start:], []byte(link.URL)); i >= 0 && link.URL != "" {
+		rules.Locate(f, doc, start+i, len(link.URL))
+		return
+	}
+
+	rules.Locate(f, doc, link.Offset, 0)
+}
+
+// external parses raw and reports whether it links outside the message,
+// either with an http(s) scheme or as a scheme relative "//host" link.
+func external(raw string) (*url.URL, bool) {
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		// no list can match it, so it is treated as any other link
+		return &url.URL{}, strings.HasPrefix(strings.ToLower(raw), "http")
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u, true
+	case "":
+		return u, u.Host != ""
+	}
+
+	return u, false
+}
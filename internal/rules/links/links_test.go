@@ -0,0 +1,242 @@
+package links
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/imageprobe"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockProber struct{}
+
+func (mockProber) Probe(ctx context.Context, url string) imageprobe.Result {
+
+	if strings.Contains(url, "127.0.0.1") {
+		return imageprobe.Result{URL: url, ErrClass: imageprobe.ClassBlocked}
+	}
+
+	if strings.HasSuffix(url, ".png") {
+		return imageprobe.Result{URL: url, MIME: "image/png"}
+	}
+
+	return imageprobe.Result{URL: url, MIME: "text/html", ErrClass: imageprobe.ClassNotImage}
+}
+
+func mockRule() *linkRule {
+	return &linkRule{
+		images:   mockProber{},
+		deadline: time.Second,
+	}
+}
+
+func TestEveryLinkOnALine(t *testing.T) {
+
+	doc := markdown.Parse("# Title\n\n[internal](#section) then [external](https://example.com) and ![a](https://example.com/a.png) ![b](https://example.com/b.png)")
+
+	findings, err := mockRule().Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, findings, 3)
+
+	targets := map[string]string{}
+	for _, f := range findings {
+		targets[f.Target] = f.Severity
+	}
+
+	assert.Equal(t, rules.SeverityReject, targets["https://example.com"])
+	assert.Equal(t, rules.SeverityReview, targets["https://example.com/a.png"])
+	assert.Equal(t, rules.SeverityReview, targets["https://example.com/b.png"])
+}
+
+func TestLinkPositions(t *testing.T) {
+
+	doc := markdown.Parse("# Title\n\nSome text\nsee [here](https://example.com)\n\n<img src=\"https://example.com/a.png\">")
+
+	findings, err := mockRule().Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if assert.Len(t, findings, 2) {
+		assert.Equal(t, CodeExternalLink, findings[0].Code)
+		assert.Equal(t, 4, findings[0].Line)
+		assert.Equal(t, 12, findings[0].Column)
+		assert.Equal(t, 31, findings[0].EndColumn)
+
+		assert.Equal(t, CodeImageReview, findings[1].Code)
+		assert.Equal(t, 6, findings[1].Line)
+		assert.Equal(t, 11, findings[1].Column)
+	}
+}
+
+func TestRepeatedImage(t *testing.T) {
+
+	doc := markdown.Parse("# Title\n\n![a](https://example.com/a.png) ![again](https://example.com/a.png)\n\n<img src=\"https://example.com/a.png\">")
+
+	findings, err := mockRule().Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, findings, 1)
+	assert.Equal(t, rules.SeverityReview, findings[0].Severity)
+}
+
+func TestInternalLinks(t *testing.T) {
+
+	doc := markdown.Parse("# Title\n\n- [one](#one)\n- [two](#two)")
+
+	findings, err := mockRule().Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, findings, 0)
+}
+
+func TestDomainLists(t *testing.T) {
+
+	rule, err := New(&config.RawConfig{
+		"allow":  []interface{}{"docs.example.com", "*.partner.com", "example.org/blog"},
+		"deny":   []interface{}{"*.evil.com", "evil.com"},
+		"review": []interface{}{"https://news.example.com/"},
+	}, rules.Deps{Images: mockProber{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := rule.(*linkRule)
+
+	cases := map[string]string{
+		"https://docs.example.com/start":      "",
+		"https://DOCS.example.com./start":     "",
+		"https://www.partner.com/x":           "",
+		"https://partner.com/x":               rules.SeverityReject,
+		"https://example.org/blog":            "",
+		"https://example.org/blog/post":       "",
+		"https://example.org/blogger":         rules.SeverityReject,
+		"https://evil.com":                    rules.SeverityReject,
+		"https://cdn.evil.com/a.png":          rules.SeverityReject,
+		"https://news.example.com/story":      rules.SeverityReview,
+		"//other.example.com/page":            rules.SeverityReject,
+		"https://other.example.com/image.png": rules.SeverityReview,
+		"mailto:someone@example.com":          "",
+		"http://127.0.0.1/a.png":              rules.SeverityReject,
+	}
+
+	for link, want := range cases {
+		doc := markdown.Parse("# Title\n\n[link](" + link + ")")
+
+		findings, err := l.Evaluate(&rules.Input{Doc: doc})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, want, rules.MostSevere(findings), link)
+	}
+}
+
+func TestDomainPathBypass(t *testing.T) {
+
+	rule, err := New(&config.RawConfig{
+		"allow": []interface{}{"example.org"},
+		"deny":  []interface{}{"example.org/blog", "example.org/Private%20Files"},
+	}, rules.Deps{Images: mockProber{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := rule.(*linkRule)
+
+	cases := map[string]string{
+		"https://example.org/about":             "",
+		"https://example.org/blogger":           "",
+		"https://example.org/blog":              rules.SeverityReject,
+		"https://example.org/Blog":              rules.SeverityReject,
+		"https://example.org/BLOG/post":         rules.SeverityReject,
+		"https://example.org/%62log":            rules.SeverityReject,
+		"https://example.org/%62%6C%6F%67/post": rules.SeverityReject,
+		"https://example.org//blog":             rules.SeverityReject,
+		"https://example.org/about/../blog":     rules.SeverityReject,
+		"https://example.org/./blog/":           rules.SeverityReject,
+		"https://example.org/private%20files/a": rules.SeverityReject,
+	}
+
+	for link, want := range cases {
+		doc := markdown.Parse("# Title\n\n[link](" + link + ")")
+
+		findings, err := l.Evaluate(&rules.Input{Doc: doc})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, want, rules.MostSevere(findings), link)
+	}
+}
+
+func TestInvalidDomain(t *testing.T) {
+
+	_, err := New(&config.RawConfig{
+		"allow": []interface{}{"*.*.example.com"},
+	}, rules.Deps{Images: mockProber{}})
+	assert.Error(t, err)
+}
+
+// slowProber takes until ctx is done to probe any link.
+type slowProber struct {
+	probed *int
+}
+
+func (p slowProber) Probe(ctx context.Context, url string) imageprobe.Result {
+
+	*p.probed++
+	<-ctx.Done()
+
+	return imageprobe.Result{URL: url, ErrClass: imageprobe.ClassTimeout, Err: ctx.Err()}
+}
+
+func TestDeadline(t *testing.T) {
+
+	probed := 0
+
+	rule, err := New(&config.RawConfig{"deadline": "20ms"}, rules.Deps{Images: slowProber{&probed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := markdown.Parse("# Title\n\n![a](https://example.com/a.png) ![b](https://example.com/b.png) ![c](https://example.com/c.png)")
+
+	start := time.Now()
+
+	findings, err := rule.Evaluate(&rules.Input{Doc: doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the links share one deadline rather than each waiting for it
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, 3, probed)
+
+	if assert.Len(t, findings, 3) {
+		for _, f := range findings {
+			assert.Equal(t, CodeExternalLink, f.Code)
+		}
+	}
+
+	_, err = New(&config.RawConfig{"deadline": "0s"}, rules.Deps{Images: slowProber{&probed}})
+	assert.Error(t, err)
+}
+
+func TestNoProber(t *testing.T) {
+
+	_, err := New(&config.RawConfig{}, rules.Deps{})
+	assert.Error(t, err)
+}
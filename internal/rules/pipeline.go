@@ -0,0 +1,103 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/kramllih/filterService/config"
+)
+
+// DefaultRules is the pipeline used when no rules are configured.
+var DefaultRules = []string{"structure", "limits", "bannedwords", "links"}
+
+type ruleCfg struct {
+	Type string
+}
+
+// Pipeline runs an ordered list of rules against a message.
+type Pipeline struct {
+	rules []Rule
+}
+
+// NewPipeline builds a pipeline from the rules section of the config. Each
+// entry must have a type, the rest of the entry is passed to the rule.
+func NewPipeline(cfgs []*config.RawConfig, deps Deps) (*Pipeline, error) {
+
+	if len(cfgs) == 0 {
+		for _, name := range DefaultRules {
+			cfgs = append(cfgs, &config.RawConfig{"type": name})
+		}
+	}
+
+	p := &Pipeline{}
+
+	for i, cfg := range cfgs {
+		rc := ruleCfg{}
+
+		if err := cfg.UnpackRaw(&rc); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		if rc.Type == "" {
+			return nil, fmt.Errorf("rule %d: no type configured", i)
+		}
+
+		rule, err := Load(rc.Type, cfg, deps)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		p.rules = append(p.rules, rule)
+	}
+
+	return p, nil
+}
+
+func (p *Pipeline) Rules() []Rule {
+	return p.rules
+}
+
+// Evaluate runs every rule in order and returns all of their findings.
+func (p *Pipeline) Evaluate(in *Input) ([]Finding, error) {
+
+	findings := []Finding{}
+
+	for _, rule := range p.rules {
+		found, err := rule.Evaluate(in)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.Name(), err)
+		}
+
+		findings = append(findings, found...)
+	}
+
+	return findings, nil
+}
+
+// Check runs the rules that are checkers and returns all of their findings.
+func (p *Pipeline) Check(in *Input) []Finding {
+
+	findings := []Finding{}
+
+	for _, rule := range p.rules {
+		if checker, ok := rule.(Checker); ok {
+			findings = append(findings, checker.Check(in)...)
+		}
+	}
+
+	return findings
+}
+
+// Precheck runs the rules that are precheckers and returns all of their
+// findings.
+func (p *Pipeline) Precheck(in *Input) []Finding {
+
+	findings := []Finding{}
+
+	for _, rule := range p.rules {
+		if prechecker, ok := rule.(Prechecker); ok {
+			findings = append(findings, prechecker.Precheck(in)...)
+		}
+	}
+
+	return findings
+}
@@ -0,0 +1,63 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticRule struct {
+	name     string
+	findings []Finding
+}
+
+func (s *staticRule) Name() string {
+	return s.name
+}
+
+func (s *staticRule) Evaluate(in *Input) ([]Finding, error) {
+	return s.findings, nil
+}
+
+func init() {
+	RegisterType("test-first", func(cfg *config.RawConfig, deps Deps) (Rule, error) {
+		return &staticRule{name: "test-first", findings: []Finding{{Rule: "test-first", Severity: SeverityReview}}}, nil
+	})
+	RegisterType("test-second", func(cfg *config.RawConfig, deps Deps) (Rule, error) {
+		return &staticRule{name: "test-second", findings: []Finding{{Rule: "test-second", Severity: SeverityReject}}}, nil
+	})
+}
+
+func TestPipelineOrder(t *testing.T) {
+
+	p, err := NewPipeline([]*config.RawConfig{
+		{"type": "test-second"},
+		{"type": "test-first"},
+	}, Deps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := p.Evaluate(&Input{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, findings, 2)
+	assert.Equal(t, "test-second", findings[0].Rule)
+	assert.Equal(t, "test-first", findings[1].Rule)
+}
+
+func TestPipelineUnknownRule(t *testing.T) {
+
+	_, err := NewPipeline([]*config.RawConfig{
+		{"type": "does-not-exist"},
+	}, Deps{})
+	assert.Error(t, err)
+
+	_, err = NewPipeline([]*config.RawConfig{
+		{"name": "no type"},
+	}, Deps{})
+	assert.Error(t, err)
+}
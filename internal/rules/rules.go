@@ -0,0 +1,135 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/imageprobe"
+	"github.com/kramllih/filterService/internal/markdown"
+)
+
+// SeverityInvalid is for a message that breaks a limit, it is refused
+// rather than stored as rejected.
+const (
+	SeverityInvalid string = "invalid"
+	SeverityReject  string = "reject"
+	SeverityReview  string = "review"
+	SeverityMask    string = "mask"
+)
+
+var severityRank = map[string]int{
+	SeverityMask:    1,
+	SeverityReview:  2,
+	SeverityReject:  3,
+	SeverityInvalid: 4,
+}
+
+// MoreSevere reports whether severity a is more severe than b.
+func MoreSevere(a, b string) bool {
+	return severityRank[a] > severityRank[b]
+}
+
+// MostSevere returns the highest severity of the findings, or an empty
+// string when there are none.
+func MostSevere(findings []Finding) string {
+
+	severity := ""
+	for _, f := range findings {
+		if MoreSevere(f.Severity, severity) {
+			severity = f.Severity
+		}
+	}
+
+	return severity
+}
+
+// Finding is a single problem a rule found in a message.
+type Finding = database.Finding
+
+// Locate sets where in the document the finding is, from the byte offset
+// and length of the text that caused it.
+func Locate(f *Finding, doc *markdown.Document, offset, length int) {
+
+	f.Offset = offset
+	f.Length = length
+
+	f.Line, f.Column = doc.Position(offset)
+	f.EndLine, f.EndColumn = doc.Position(offset + length)
+}
+
+// Input is what every rule is evaluated against, Doc is the message body
+// parsed as Markdown. Rules must not write anything during a DryRun.
+type Input struct {
+	Message *database.Message
+	Doc     *markdown.Document
+	DryRun  bool
+}
+
+type Rule interface {
+	Name() string
+	Evaluate(in *Input) ([]Finding, error)
+}
+
+// Checker is a rule that only measures the message itself. Checkers are
+// also run before a message is accepted, so what they find can be returned
+// to the sender straight away.
+type Checker interface {
+	Rule
+	Check(in *Input) []Finding
+}
+
+// Prechecker is a checker with checks that only need the body's source.
+// They are run before the body is parsed, Doc is then unparsed, so a body
+// that is far too large is turned away cheaply.
+type Prechecker interface {
+	Checker
+	Precheck(in *Input) []Finding
+}
+
+// WordSource supplies the current banned word list. The version changes
+// whenever the list does, so anything built from the list can be kept
+// until then.
+type WordSource interface {
+	BannedWords() ([]string, uint64, error)
+	// ReadOnlyWords is BannedWords for dry runs, it doesn't save anything.
+	ReadOnlyWords() ([]string, uint64, error)
+}
+
+// ImageProber finds out whether a url points at an image, giving up when
+// ctx is done.
+type ImageProber interface {
+	Probe(ctx context.Context, url string) imageprobe.Result
+}
+
+// Deps holds the shared services a rule can be built with.
+type Deps struct {
+	Words  WordSource
+	Images ImageProber
+}
+
+type Factory func(config *config.RawConfig, deps Deps) (Rule, error)
+
+var cache = map[string]Factory{}
+
+func RegisterType(name string, f Factory) {
+	if cache[name] != nil {
+		panic(fmt.Errorf("rule type '%v' exists already", name))
+	}
+	cache[name] = f
+}
+
+func FindFactory(name string) Factory {
+	return cache[name]
+}
+
+func Load(name string, config *config.RawConfig, deps Deps) (Rule, error) {
+
+	factory := FindFactory(name)
+	if factory == nil {
+		return nil, fmt.Errorf("rule type %v undefined", name)
+	}
+
+	return factory(config, deps)
+}
@@ -0,0 +1,304 @@
+package structure
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/yuin/goldmark/ast"
+)
+
+const Name string = "structure"
+
+// Codes of the findings the rule makes.
+const (
+	CodeMissingH1         string = "MISSING_H1"
+	CodeMissingHeading    string = "MISSING_HEADING"
+	CodeHeadingNotAllowed string = "HEADING_NOT_ALLOWED"
+	CodeHeadingTooDeep    string = "HEADING_TOO_DEEP"
+	CodeMissingBody       string = "MISSING_BODY"
+	CodeBannedHTML        string = "BANNED_HTML"
+	CodeMissingSection    string = "MISSING_SECTION"
+)
+
+// What a profile needs of the first block of a message.
+const (
+	HeadingRequired string = "required"
+	HeadingOptional string = "optional"
+	HeadingNone     string = "none"
+)
+
+// DefaultProfile is the profile used for messages without a channel, or
+// with a channel that has no profile of its own.
+const DefaultProfile string = "default"
+
+// Profile is the shape a message must have. Heading says whether the first
+// block must be a heading, and HeadingLevels which levels it can be, any
+// level when empty. MinBody is the number of blocks needed after a leading
+// heading. A banned tag of "*" bans all raw HTML. Anything left unset isn't
+// checked.
+type Profile struct {
+	Heading          string
+	HeadingLevels    []int
+	MaxHeadingDepth  int
+	MinBody          int
+	BannedTags       []string
+	RequiredSections []string
+}
+
+type structureCfg struct {
+	Profiles map[string]Profile
+}
+
+type structureRule struct {
+	profiles map[string]Profile
+}
+
+func init() {
+	rules.RegisterType(Name, New)
+}
+
+func New(cfg *config.RawConfig, deps rules.Deps) (rules.Rule, error) {
+
+	structureConfig := structureCfg{}
+
+	if err := cfg.UnpackRaw(&structureConfig); err != nil {
+		return nil, err
+	}
+
+	s := &structureRule{
+		profiles: map[string]Profile{
+			DefaultProfile: {
+				Heading:       HeadingRequired,
+				HeadingLevels: []int{1},
+				MinBody:       1,
+			},
+		},
+	}
+
+	for name, profile := range structureConfig.Profiles {
+		if err := check(&profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+
+		// channels are matched without case, as the config keys are
+		s.profiles[strings.ToLower(name)] = profile
+	}
+
+	return s, nil
+}
+
+// check sets the defaults of a profile and makes sure it is valid.
+func check(p *Profile) error {
+
+	switch p.Heading {
+	case "":
+		p.Heading = HeadingOptional
+	case HeadingRequired, HeadingOptional, HeadingNone:
+	default:
+		return fmt.Errorf("unknown heading %q, it must be one of required, optional or none", p.Heading)
+	}
+
+	for _, level := range p.HeadingLevels {
+		if level < 1 || level > 6 {
+			return fmt.Errorf("heading level %d must be between 1 and 6", level)
+		}
+	}
+
+	if p.MaxHeadingDepth < 0 || p.MaxHeadingDepth > 6 {
+		return errors.New("maxHeadingDepth must be between 0 and 6")
+	}
+
+	if p.MinBody < 0 {
+		return errors.New("minBody can't be negative")
+	}
+
+	for i, tag := range p.BannedTags {
+		p.BannedTags[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+
+	return nil
+}
+
+func (s *structureRule) Name() string {
+	return Name
+}
+
+// Evaluate returns the same findings as Check.
+func (s *structureRule) Evaluate(in *rules.Input) ([]rules.Finding, error) {
+	return s.Check(in), nil
+}
+
+// Check measures the message against the profile for its channel.
+func (s *structureRule) Check(in *rules.Input) []rules.Finding {
+
+	if in.Doc == nil {
+		return nil
+	}
+
+	profile := s.profile(in.Message)
+	doc := in.Doc
+
+	findings := []rules.Finding{}
+	blocks := doc.Blocks()
+
+	leading := len(blocks) > 0 && blocks[0].Kind() == ast.KindHeading
+
+	switch profile.Heading {
+	case HeadingRequired:
+		if !leading || !allowed(profile.HeadingLevels, blocks[0].(*ast.Heading).Level) {
+			code, reason := missingHeading(profile.HeadingLevels)
+
+			f := finding(code, reason)
+			rules.Locate(&f, doc, 0, 0)
+
+			findings = append(findings, f)
+		}
+
+	case HeadingNone:
+		for _, heading := range doc.Headings {
+			f := finding(CodeHeadingNotAllowed, fmt.Sprintf("heading [%s] is not allowed", heading.Text))
+			f.Target = heading.Text
+			rules.Locate(&f, doc, heading.Offset, len(heading.Text))
+
+			findings = append(findings, f)
+		}
+	}
+
+	if profile.MaxHeadingDepth > 0 && profile.Heading != HeadingNone {
+		for _, heading := range doc.Headings {
+			if heading.Level <= profile.MaxHeadingDepth {
+				continue
+			}
+
+			f := finding(CodeHeadingTooDeep, fmt.Sprintf("heading [%s] is level %d, the deepest allowed is %d", heading.Text, heading.Level, profile.MaxHeadingDepth))
+			f.Target = heading.Text
+			rules.Locate(&f, doc, heading.Offset, len(heading.Text))
+
+			findings = append(findings, f)
+		}
+	}
+
+	body := len(blocks)
+	if leading {
+		body--
+	}
+
+	if body < profile.MinBody {
+		reason := "body must contain at least 1 paragraph of text"
+		if profile.MinBody > 1 {
+			reason = fmt.Sprintf("body must contain at least %d paragraphs of text", profile.MinBody)
+		}
+
+		f := finding(CodeMissingBody, reason)
+		rules.Locate(&f, doc, len(doc.Source), 0)
+
+		findings = append(findings, f)
+	}
+
+	for _, tag := range doc.Tags {
+		if !banned(profile.BannedTags, tag.Name) {
+			continue
+		}
+
+		f := finding(CodeBannedHTML, fmt.Sprintf("raw HTML tag <%s> is not allowed", tag.Name))
+		f.Target = tag.Name
+		rules.Locate(&f, doc, tag.Offset, len(tag.Name)+1)
+
+		findings = append(findings, f)
+	}
+
+	for _, section := range profile.RequiredSections {
+		if hasSection(doc, section) {
+			continue
+		}
+
+		f := finding(CodeMissingSection, fmt.Sprintf("section [%s] is missing", section))
+		f.Target = section
+		rules.Locate(&f, doc, len(doc.Source), 0)
+
+		findings = append(findings, f)
+	}
+
+	return findings
+}
+
+// profile returns the profile for the channel of message.
+func (s *structureRule) profile(message *database.Message) Profile {
+
+	if message != nil && message.Channel != "" {
+		if profile, ok := s.profiles[strings.ToLower(message.Channel)]; ok {
+			return profile
+		}
+	}
+
+	return s.profiles[DefaultProfile]
+}
+
+func missingHeading(levels []int) (string, string) {
+
+	if len(levels) == 0 {
+		return CodeMissingHeading, "first line must be a heading"
+	}
+
+	if len(levels) == 1 && levels[0] == 1 {
+		return CodeMissingH1, "first line must be a level 1 heading"
+	}
+
+	names := []string{}
+	for _, level := range levels {
+		names = append(names, fmt.Sprint(level))
+	}
+
+	return CodeMissingHeading, fmt.Sprintf("first line must be a level %s heading", strings.Join(names, " or "))
+}
+
+func allowed(levels []int, level int) bool {
+
+	if len(levels) == 0 {
+		return true
+	}
+
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+
+	return false
+}
+
+func banned(tags []string, name string) bool {
+
+	for _, tag := range tags {
+		if tag == "*" || tag == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasSection(doc *markdown.Document, section string) bool {
+
+	for _, heading := range doc.Headings {
+		if strings.EqualFold(strings.TrimSpace(heading.Text), strings.TrimSpace(section)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func finding(code, reason string) rules.Finding {
+	return rules.Finding{
+		Rule:     Name,
+		Code:     code,
+		Severity: rules.SeverityInvalid,
+		Reason:   reason,
+	}
+}
@@ -0,0 +1,135 @@
+package structure
+
+import (
+	"testing"
+
+	"github.com/kramllih/filterService/config"
+	"github.com/kramllih/filterService/internal/database"
+	"github.com/kramllih/filterService/internal/markdown"
+	"github.com/kramllih/filterService/internal/rules"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockRule(t *testing.T, cfg config.RawConfig) *structureRule {
+
+	rule, err := New(&cfg, rules.Deps{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rule.(*structureRule)
+}
+
+func codes(rule *structureRule, channel, body string) []string {
+
+	findings := rule.Check(&rules.Input{
+		Message: &database.Message{Channel: channel},
+		Doc:     markdown.Parse(body),
+	})
+
+	found := []string{}
+	for _, f := range findings {
+		found = append(found, f.Code)
+	}
+
+	return found
+}
+
+func TestDefaultProfile(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{})
+
+	cases := map[string][]string{
+		"# Heading\n\nSome text":            {},
+		"Setext\n===\n\nSome text":          {},
+		"Just some text\n\nand more":        {CodeMissingH1},
+		"## Heading\n\nSome text":           {CodeMissingH1},
+		"# Heading":                         {CodeMissingBody},
+		"   \n\n  ":                         {CodeMissingH1, CodeMissingBody},
+		"# Heading\n\n<script>x</script>\n": {},
+	}
+
+	for body, want := range cases {
+		assert.Equal(t, want, codes(rule, "", body), body)
+	}
+
+	// a channel without a profile uses the default
+	assert.Equal(t, []string{CodeMissingH1}, codes(rule, "unknown", "text"))
+}
+
+func TestProfiles(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{
+		"profiles": map[string]interface{}{
+			"chat": map[string]interface{}{
+				"heading":    "none",
+				"bannedTags": []interface{}{"*"},
+			},
+			"article": map[string]interface{}{
+				"heading":          "required",
+				"headingLevels":    []interface{}{1, 2},
+				"maxHeadingDepth":  3,
+				"minBody":          2,
+				"bannedTags":       []interface{}{"Script", "iframe"},
+				"requiredSections": []interface{}{"Summary"},
+			},
+		},
+	})
+
+	cases := map[string]map[string][]string{
+		"chat": {
+			"just a line":           {},
+			"# Heading\n\ntext":     {CodeHeadingNotAllowed},
+			"some <b>bold</b> text": {CodeBannedHTML},
+		},
+		"Article": {
+			"## Title\n\ntext\n\n## Summary\n\nmore":                   {},
+			"### Title\n\ntext\n\n## Summary\n\nmore":                  {CodeMissingHeading},
+			"# Title\n\ntext\n\n#### Summary\n\nmore":                  {CodeHeadingTooDeep},
+			"# Title\n\n## Summary":                                    {CodeMissingBody},
+			"# Title\n\ntext\n\nmore":                                  {CodeMissingSection},
+			"# Title\n\n<b>ok</b>\n\n<script>x</script>\n\n## Summary": {CodeBannedHTML},
+		},
+	}
+
+	for channel, bodies := range cases {
+		for body, want := range bodies {
+			assert.Equal(t, want, codes(rule, channel, body), channel+": "+body)
+		}
+	}
+}
+
+func TestFindingPositions(t *testing.T) {
+
+	rule := mockRule(t, config.RawConfig{
+		"profiles": map[string]interface{}{
+			"chat": map[string]interface{}{"heading": "none"},
+		},
+	})
+
+	findings := rule.Check(&rules.Input{
+		Message: &database.Message{Channel: "chat"},
+		Doc:     markdown.Parse("text\n\n## A heading"),
+	})
+
+	if assert.Len(t, findings, 1) {
+		assert.Equal(t, "A heading", findings[0].Target)
+		assert.Equal(t, rules.SeverityInvalid, findings[0].Severity)
+		assert.Equal(t, 3, findings[0].Line)
+		assert.Equal(t, 4, findings[0].Column)
+	}
+}
+
+func TestInvalidProfile(t *testing.T) {
+
+	cfgs := []config.RawConfig{
+		{"profiles": map[string]interface{}{"a": map[string]interface{}{"heading": "sometimes"}}},
+		{"profiles": map[string]interface{}{"a": map[string]interface{}{"headingLevels": []interface{}{7}}}},
+		{"profiles": map[string]interface{}{"a": map[string]interface{}{"minBody": -1}}},
+	}
+
+	for _, cfg := range cfgs {
+		_, err := New(&cfg, rules.Deps{})
+		assert.Error(t, err)
+	}
+}